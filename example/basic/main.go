@@ -12,6 +12,8 @@ import (
 	"log"
 	"log/slog"
 	"net/http"
+	"os"
+	"time"
 )
 
 func main() {
@@ -19,6 +21,7 @@ func main() {
 	h := New()
 	// WebSocket route
 	r.HandleFunc("/ws", h.handleWebSocket)
+	r.HandleFunc("/ticket", h.handleTicket)
 
 	// Static file serving
 	r.PathPrefix("/").Handler(http.FileServer(http.Dir("./static/")))
@@ -32,50 +35,84 @@ func main() {
 }
 
 type handle struct {
+	client     redis.UniversalClient
 	redisStore *redis_store.RedisStore
+	tickets    *wssession.TicketAuthenticator
 }
 
 func New() *handle {
-	redisStore := redis_store.NewRedis(redis.NewClient(&redis.Options{
+	// one pool shared by the session cache and every queue
+	client := redis.NewClient(&redis.Options{
 		Addr: "127.0.0.1:6379",
-	}))
+	})
+	secret := os.Getenv("MULTIWS_TICKET_SECRET")
+	if secret == "" {
+		secret = "dev-secret"
+	}
 	return &handle{
-		redisStore: redisStore,
+		client:     client,
+		redisStore: redis_store.NewRedis(client),
+		tickets:    wssession.NewTicketAuthenticator([]byte(secret)),
 	}
 }
-func (h *handle) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	slog.Info("consumer group", "session", r.URL.Query().Get("session"), "group", r.URL.Query().Get("name"))
-	q, err := wsqueue.NewRedisQueue[wsmodels.Event]("127.0.0.1:6379",
-		"", 0, 10, r.URL.Query().Get("session")+r.URL.Query().Get("name"), r.URL.Query().Get("name"))
+
+// handleTicket issues a WebSocket ticket. A real application would issue it
+// for the user of its own login session instead of trusting the name.
+func (h *handle) handleTicket(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	ticket, err := h.tickets.Issue(&wsmodels.User{Id: name, Name: name}, time.Minute)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	defer q.Close()
+	_, _ = w.Write([]byte(ticket))
+}
 
-	s := wssession.NewBaseSession(redis.NewClient(&redis.Options{
-		Addr: "127.0.0.1:6379",
-	}), q)
-	u := wsmodels.User{
-		Name: r.URL.Query().Get("name"),
+func (h *handle) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.URL.Query().Get("session")
+	u, err := h.tickets.Authenticate(r)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
 	}
-	slog.Info("user connected", "user", u)
-	key := r.URL.Query().Get("session") + "_session_info"
-	results := redis.NewClient(&redis.Options{
-		Addr: "127.0.0.1:6379",
-	}).Get(r.Context(), key)
-	if results.Err() != nil {
-		slog.Error(results.Err().Error())
+	// one group per user, so a reconnecting user picks up where it left off
+	// instead of leaving a group behind for every connection
+	group := sessionID + u.Id
+	slog.Info("consumer group", "session", sessionID, "group", group)
+	q, err := wsqueue.NewRedisQueueFromClient[wsmodels.Event](h.client,
+		wsqueue.WithChannelSize(10),
+		wsqueue.WithGroup(group),
+		wsqueue.WithConsumer(u.Id),
+		wsqueue.WithTopicRetention("ses_", wsqueue.Retention{
+			MaxLen:  1000,
+			MaxAge:  time.Hour,
+			IdleTTL: 6 * time.Hour,
+		}))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	slog.Info("results", "results", string(results.String()))
-	err = s.Init(context.Background(), r.URL.Query().Get("session"), &u)
+	defer q.Close(context.Background())
+
+	s := wssession.NewBaseSession(h.client, q, wssession.WithAuthenticator(h.tickets))
+	if _, err := s.Authenticate(r); err != nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	slog.Info("user connected", "user", u)
+	err = s.Init(context.Background(), sessionID, u)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	// a reconnecting client passes the StreamID of the last event it received
+	// as last_event_id and is sent the events it missed
 	hs := s.WsHandler(func(w http.ResponseWriter, r *http.Request, receiveEvent wsmodels.Event) {
-		fmt.Println("received event", receiveEvent, "from", r.URL.Query().Get("name"))
+		fmt.Println("received event", receiveEvent, "from", u.Name)
 	})
 	hs(w, r)
 
@@ -0,0 +1,95 @@
+package wsmodels
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec serializes models for a wire: the queue between pods or the
+// WebSocket between server and client.
+type Codec interface {
+	// Name identifies the codec, e.g. "json".
+	Name() string
+	// Binary reports whether encoded values must be sent as binary
+	// WebSocket frames rather than text frames.
+	Binary() bool
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	JSON     Codec = jsonCodec{}
+	MsgPack  Codec = msgpackCodec{}
+	Protobuf Codec = protobufCodec{}
+)
+
+// CodecByName returns the built-in codec called name.
+func CodecByName(name string) (Codec, error) {
+	for _, c := range []Codec{JSON, MsgPack, Protobuf} {
+		if c.Name() == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown codec %q", name)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Binary() bool { return false }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (msgpackCodec) Binary() bool { return true }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+// protobufCodec handles generated proto.Message types as well as Event,
+// which is encoded by hand following event.proto so no generated code is
+// needed.
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string { return "protobuf" }
+
+func (protobufCodec) Binary() bool { return true }
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	switch m := v.(type) {
+	case Event:
+		return m.marshalProto(), nil
+	case *Event:
+		return m.marshalProto(), nil
+	case proto.Message:
+		return proto.Marshal(m)
+	}
+	return nil, fmt.Errorf("protobuf: cannot marshal %T", v)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	switch m := v.(type) {
+	case *Event:
+		return m.unmarshalProto(data)
+	case proto.Message:
+		return proto.Unmarshal(data, m)
+	}
+	return fmt.Errorf("protobuf: cannot unmarshal into %T", v)
+}
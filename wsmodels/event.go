@@ -1,7 +1,6 @@
 package wsmodels
 
 import (
-	"encoding/json"
 	"fmt"
 	"log/slog"
 )
@@ -11,19 +10,99 @@ const (
 	EventTypeUserLeft        string = "UserLeft"
 	EventTypeUserDataChanged string = "UserDataChanged"
 	EventTypeGeneral         string = "General"
+	// EventTypeError is sent by the server to a single client whose message
+	// was rejected; Message says why.
+	EventTypeError string = "Error"
+	// EventTypeDenied is sent to a client whose event or action was refused
+	// by the session's policy; Data holds a Denial.
+	EventTypeDenied string = "Denied"
+	// EventTypeHostChanged announces a new session host; Data holds the
+	// host's User.
+	EventTypeHostChanged string = "HostChanged"
+	// EventTypeSessionSnapshot is sent to a client when it connects; Data
+	// holds the Session with its full roster.
+	EventTypeSessionSnapshot string = "SessionSnapshot"
+	// EventTypeHistory is sent to a client when it connects; Data holds the
+	// recent session history, oldest first.
+	EventTypeHistory string = "History"
+	// EventTypeResync is sent to a reconnecting client whose missed events
+	// could not be replayed; it should rely on the snapshot and history
+	// sent on connect instead.
+	EventTypeResync string = "Resync"
+	// EventTypeMetaChanged announces a change of the shared Session.Meta;
+	// Data holds a MetaChange.
+	EventTypeMetaChanged string = "MetaChanged"
 )
 
+// Denial explains why a policy refused an event or action.
+type Denial struct {
+	EventType string   `json:"eventType"`
+	Reason    string   `json:"reason"`
+	Roles     []string `json:"roles,omitempty"`
+}
+
+// MetaChange is a new value of one Session.Meta key. A nil Value means the
+// key was removed.
+type MetaChange struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// systemEventTypes are produced by the server on behalf of users; clients
+// may not send them directly.
+var systemEventTypes = map[string]bool{
+	EventTypeUserJoined:      true,
+	EventTypeUserLeft:        true,
+	EventTypeUserDataChanged: true,
+	EventTypeError:           true,
+	EventTypeDenied:          true,
+	EventTypeHostChanged:     true,
+	EventTypeSessionSnapshot: true,
+	EventTypeHistory:         true,
+	EventTypeResync:          true,
+	EventTypeMetaChanged:     true,
+}
+
+// IsSystemEvent reports whether events of type t are reserved for the
+// server.
+func IsSystemEvent(t string) bool {
+	return systemEventTypes[t]
+}
+
 type Event struct {
-	SenderID   string
-	ReceiverID string
-	Type       string
-	Data       string
-	Message    string
-	Remote     bool
+	// ID is assigned when the event is published unless already set
+	ID string
+	// CorrelationID is the ID of the request this event answers
+	CorrelationID string
+	SenderID      string
+	ReceiverID    string
+	Type          string
+	Data          string
+	Message       string
+	Remote        bool
+	// StreamID is the queue entry the event was delivered from. Clients
+	// present the last one they saw when reconnecting to resume from it.
+	StreamID string
+}
+
+// Reply returns an event answering e: addressed to its sender and
+// correlated with its ID.
+func (e *Event) Reply() Event {
+	return Event{
+		CorrelationID: e.ID,
+		ReceiverID:    e.SenderID,
+		Type:          e.Type,
+	}
 }
 
 func (e *Event) Set(data interface{}) (err error) {
-	d, err := json.Marshal(data)
+	return e.SetWith(JSON, data)
+}
+
+// SetWith encodes data into the event with codec. Binary codecs only
+// survive the trip when the event itself is sent with a binary codec.
+func (e *Event) SetWith(codec Codec, data interface{}) (err error) {
+	d, err := codec.Marshal(data)
 	if err != nil {
 		return err
 	}
@@ -32,12 +111,17 @@ func (e *Event) Set(data interface{}) (err error) {
 }
 
 func GetDataEvent[T any](e Event) (out *T, err error) {
+	return GetDataEventWith[T](JSON, e)
+}
+
+// GetDataEventWith decodes the event data written by SetWith with codec.
+func GetDataEventWith[T any](codec Codec, e Event) (out *T, err error) {
 	var t T
 	slog.Info("GetDataEvent[", "data", e)
 	if e.Data == "" {
 		return nil, fmt.Errorf("event data is empty")
 	}
-	err = json.Unmarshal([]byte(e.Data), &t)
+	err = codec.Unmarshal([]byte(e.Data), &t)
 	if err != nil {
 		return
 	}
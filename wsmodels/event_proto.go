@@ -0,0 +1,98 @@
+package wsmodels
+
+import (
+	"fmt"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers from event.proto.
+const (
+	eventFieldSenderID      protowire.Number = 1
+	eventFieldReceiverID    protowire.Number = 2
+	eventFieldType          protowire.Number = 3
+	eventFieldData          protowire.Number = 4
+	eventFieldMessage       protowire.Number = 5
+	eventFieldRemote        protowire.Number = 6
+	eventFieldID            protowire.Number = 7
+	eventFieldCorrelationID protowire.Number = 8
+	eventFieldStreamID      protowire.Number = 9
+)
+
+func (e *Event) marshalProto() []byte {
+	var b []byte
+	appendString := func(n protowire.Number, v string) {
+		if v == "" {
+			return
+		}
+		b = protowire.AppendTag(b, n, protowire.BytesType)
+		b = protowire.AppendString(b, v)
+	}
+	appendString(eventFieldSenderID, e.SenderID)
+	appendString(eventFieldReceiverID, e.ReceiverID)
+	appendString(eventFieldType, e.Type)
+	appendString(eventFieldData, e.Data)
+	appendString(eventFieldMessage, e.Message)
+	if e.Remote {
+		b = protowire.AppendTag(b, eventFieldRemote, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	appendString(eventFieldID, e.ID)
+	appendString(eventFieldCorrelationID, e.CorrelationID)
+	appendString(eventFieldStreamID, e.StreamID)
+	return b
+}
+
+func (e *Event) unmarshalProto(b []byte) error {
+	*e = Event{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("protobuf: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		var s *string
+		switch num {
+		case eventFieldSenderID:
+			s = &e.SenderID
+		case eventFieldReceiverID:
+			s = &e.ReceiverID
+		case eventFieldType:
+			s = &e.Type
+		case eventFieldData:
+			s = &e.Data
+		case eventFieldMessage:
+			s = &e.Message
+		case eventFieldID:
+			s = &e.ID
+		case eventFieldCorrelationID:
+			s = &e.CorrelationID
+		case eventFieldStreamID:
+			s = &e.StreamID
+		}
+		switch {
+		case s != nil && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return fmt.Errorf("protobuf: %w", protowire.ParseError(n))
+			}
+			*s = v
+			b = b[n:]
+		case num == eventFieldRemote && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return fmt.Errorf("protobuf: %w", protowire.ParseError(n))
+			}
+			e.Remote = v != 0
+			b = b[n:]
+		default:
+			// unknown or mistyped fields are skipped for forward compatibility
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return fmt.Errorf("protobuf: %w", protowire.ParseError(n))
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
@@ -17,7 +17,9 @@ type Session struct {
 	MaxHistory   int                    `json:"maxHistory"`
 	IdleDuration time.Duration          `json:"idleDuration"`
 	Meta         map[string]interface{} `json:"meta"`
-	Self         User                   `json:"-"`
+	// Version increases with every change to the stored session
+	Version int64 `json:"version"`
+	Self    User  `json:"-"`
 }
 
 //func (s *Session) UnmarshalBinary(data []byte) error {
@@ -10,6 +10,8 @@ type User struct {
 	Joined   int64
 	LastSeen int64
 	Host     bool
+	// Role is checked by authorization policies, e.g. RoleViewer
+	Role string
 }
 
 const (
@@ -18,3 +20,25 @@ const (
 	StatusError        string = "error"
 	StatusIdle         string = "idle"
 )
+
+const (
+	// RoleHost is held by whichever user is currently the session host, in
+	// addition to their own Role.
+	RoleHost   string = "host"
+	RoleMember string = "member"
+	RoleViewer string = "viewer"
+)
+
+// Roles returns every role u holds. Users without a Role are members.
+func (u *User) Roles() []string {
+	roles := make([]string, 0, 2)
+	if u.Role != "" {
+		roles = append(roles, u.Role)
+	} else {
+		roles = append(roles, RoleMember)
+	}
+	if u.Host {
+		roles = append(roles, RoleHost)
+	}
+	return roles
+}
@@ -0,0 +1,49 @@
+package wsqueue
+
+import (
+	"encoding/json"
+	"github.com/Seann-Moser/multiws/wsmodels"
+)
+
+// Codec converts queue values to and from the bytes stored in a stream.
+type Codec[T any] interface {
+	Marshal(v T) ([]byte, error)
+	Unmarshal(data []byte) (T, error)
+}
+
+// JSONCodec encodes values with encoding/json. It is the default codec.
+type JSONCodec[T any] struct{}
+
+var _ Codec[any] = JSONCodec[any]{}
+
+func (JSONCodec[T]) Marshal(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec[T]) Unmarshal(data []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// CodecOf adapts one of the wsmodels codecs (JSON, MessagePack, Protobuf)
+// for use as a queue wire format, e.g.
+//
+//	WithCodec(CodecOf[wsmodels.Event](wsmodels.MsgPack))
+func CodecOf[T any](c wsmodels.Codec) Codec[T] {
+	return modelCodec[T]{c: c}
+}
+
+type modelCodec[T any] struct {
+	c wsmodels.Codec
+}
+
+func (m modelCodec[T]) Marshal(v T) ([]byte, error) {
+	return m.c.Marshal(v)
+}
+
+func (m modelCodec[T]) Unmarshal(data []byte) (T, error) {
+	var v T
+	err := m.c.Unmarshal(data, &v)
+	return v, err
+}
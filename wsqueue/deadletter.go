@@ -0,0 +1,172 @@
+package wsqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"log/slog"
+	"strconv"
+)
+
+// DeadLetterQueue is implemented by queues that keep events they could not
+// deliver instead of dropping them.
+type DeadLetterQueue interface {
+	// ListDeadLetters returns up to count dead letters for topic, oldest
+	// first, starting after the dead letter with ID after ("" for the
+	// beginning).
+	ListDeadLetters(ctx context.Context, topic, after string, count int64) ([]DeadLetter, error)
+	// ReplayDeadLetters publishes the given dead letters back into topic and
+	// removes them from the dead-letter stream.
+	ReplayDeadLetters(ctx context.Context, topic string, ids ...string) error
+}
+
+var _ DeadLetterQueue = &RedisStreamQueue[any]{}
+
+// DeadLetter is an entry that was moved out of a topic because it could not
+// be decoded or was delivered too many times.
+type DeadLetter struct {
+	// ID of the entry in the dead-letter stream.
+	ID string
+	// Topic and OriginalID locate the entry it was copied from.
+	Topic      string
+	OriginalID string
+	// Data is the original payload, untouched.
+	Data       string
+	Error      string
+	Consumer   string
+	Deliveries int64
+}
+
+// DeadLetterTopic returns the default dead-letter stream for topic.
+func DeadLetterTopic(topic string) string {
+	return topic + ":dlq"
+}
+
+// dlqTopic returns the dead-letter stream for topic, or "" if dead
+// lettering is disabled.
+func (q *RedisStreamQueue[T]) dlqTopic(topic string) string {
+	if q.opts.deadLetterTopic == nil {
+		return ""
+	}
+	return q.opts.deadLetterTopic(topic)
+}
+
+// deadLetter copies msg to the dead-letter stream with the reason it was
+// rejected and acknowledges it in the group. With dead lettering disabled
+// the entry is only acknowledged.
+func (q *RedisStreamQueue[T]) deadLetter(
+	ctx context.Context,
+	topic string,
+	msg redis.XMessage,
+	consumer string,
+	deliveries int64,
+	reason string,
+) error {
+	// entries trimmed from the stream come back without values
+	if dlq := q.dlqTopic(topic); dlq != "" && msg.Values != nil {
+		data, _ := msg.Values["data"].(string)
+		if err := q.xadd(ctx, q.opts.deadLetterRetention, dlq, map[string]interface{}{
+			"data":       data,
+			"id":         msg.ID,
+			"topic":      topic,
+			"error":      reason,
+			"consumer":   consumer,
+			"deliveries": deliveries,
+		}); err != nil {
+			return err
+		}
+	}
+	if err := q.client.XAck(ctx, topic, q.group, msg.ID).Err(); err != nil {
+		return fmt.Errorf("XAck: %w", err)
+	}
+	return nil
+}
+
+// reject dead-letters msg after it failed to decode with err.
+func (q *RedisStreamQueue[T]) reject(ctx context.Context, topic string, msg redis.XMessage, err error) {
+	slog.Error("rejecting message", "err", err, "topic", topic, "id", msg.ID)
+	var deliveries int64 = 1
+	pending, perr := q.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: topic,
+		Group:  q.group,
+		Start:  msg.ID,
+		End:    msg.ID,
+		Count:  1,
+	}).Result()
+	if perr == nil && len(pending) == 1 {
+		deliveries = pending[0].RetryCount
+	}
+	if err := q.deadLetter(ctx, topic, msg, q.consumer, deliveries, err.Error()); err != nil {
+		slog.Error("reject: dead letter", "err", err, "topic", topic, "id", msg.ID)
+	}
+}
+
+// ListDeadLetters implements DeadLetterQueue.
+func (q *RedisStreamQueue[T]) ListDeadLetters(
+	ctx context.Context,
+	topic, after string,
+	count int64,
+) ([]DeadLetter, error) {
+	dlq := q.dlqTopic(topic)
+	if dlq == "" {
+		return nil, fmt.Errorf("ListDeadLetters: dead lettering disabled")
+	}
+	start := "-"
+	if after != "" {
+		// exclusive range start
+		start = "(" + after
+	}
+	msgs, err := q.client.XRangeN(ctx, dlq, start, "+", count).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("ListDeadLetters: XRange: %w", err)
+	}
+	out := make([]DeadLetter, 0, len(msgs))
+	for _, msg := range msgs {
+		out = append(out, parseDeadLetter(msg))
+	}
+	return out, nil
+}
+
+// ReplayDeadLetters implements DeadLetterQueue. The payloads are appended to
+// topic as new entries, so every consumer group sees them again.
+func (q *RedisStreamQueue[T]) ReplayDeadLetters(ctx context.Context, topic string, ids ...string) error {
+	dlq := q.dlqTopic(topic)
+	if dlq == "" {
+		return fmt.Errorf("ReplayDeadLetters: dead lettering disabled")
+	}
+	for _, id := range ids {
+		msgs, err := q.client.XRange(ctx, dlq, id, id).Result()
+		if err != nil {
+			return fmt.Errorf("ReplayDeadLetters: XRange: %w", err)
+		}
+		if len(msgs) == 0 {
+			return fmt.Errorf("ReplayDeadLetters: dead letter %q not found", id)
+		}
+		dl := parseDeadLetter(msgs[0])
+		if err := q.xadd(ctx, q.opts.retentionFor(topic), topic, map[string]interface{}{"data": dl.Data}); err != nil {
+			return fmt.Errorf("ReplayDeadLetters: %w", err)
+		}
+		if err := q.client.XDel(ctx, dlq, id).Err(); err != nil {
+			return fmt.Errorf("ReplayDeadLetters: XDel: %w", err)
+		}
+	}
+	return nil
+}
+
+func parseDeadLetter(msg redis.XMessage) DeadLetter {
+	str := func(key string) string {
+		v, _ := msg.Values[key].(string)
+		return v
+	}
+	deliveries, _ := strconv.ParseInt(str("deliveries"), 10, 64)
+	return DeadLetter{
+		ID:         msg.ID,
+		Topic:      str("topic"),
+		OriginalID: str("id"),
+		Data:       str("data"),
+		Error:      str("error"),
+		Consumer:   str("consumer"),
+		Deliveries: deliveries,
+	}
+}
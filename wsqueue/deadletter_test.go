@@ -0,0 +1,111 @@
+package wsqueue
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedisQueueRejectsUndecodable(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	q := newTestRedisQueue(t, m, "c1")
+	ch := q.Consume(ctx, "topic")
+	if err := q.client.XAdd(ctx, &redis.XAddArgs{Stream: "topic", Values: map[string]interface{}{"data": "{broken"}}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.ProduceEvent(ctx, "topic", "fine"); err != nil {
+		t.Fatal(err)
+	}
+	// the broken entry does not hold up the next one
+	if d := receive(t, ch); d.Event != "fine" {
+		t.Fatalf("got %q, want fine", d.Event)
+	}
+	dead, err := q.ListDeadLetters(ctx, "topic", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].Data != "{broken" || !strings.Contains(dead[0].Error, "unmarshal") {
+		t.Fatalf("unexpected dead letters %+v", dead)
+	}
+}
+
+func TestRedisQueueReplayDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	q := newTestRedisQueue(t, m, "c1", WithReclaim(time.Hour, 1))
+	ch := q.Consume(ctx, "topic")
+	for _, e := range []string{"first", "second"} {
+		if err := q.ProduceEvent(ctx, "topic", e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := nackUntilDeadLettered(t, ch); n != 2 {
+		t.Fatalf("delivered %d times, want 2", n)
+	}
+
+	// pages through the dead letters one at a time
+	first, err := q.ListDeadLetters(ctx, "topic", "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest, err := q.ListDeadLetters(ctx, "topic", first[0].ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 1 || len(rest) != 1 || first[0].Data != `"first"` || rest[0].Data != `"second"` {
+		t.Fatalf("unexpected dead letters %+v %+v", first, rest)
+	}
+
+	if err := q.ReplayDeadLetters(ctx, "topic", first[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	d := receive(t, ch)
+	if d.Event != "first" {
+		t.Fatalf("got %q, want first", d.Event)
+	}
+	if err := d.Ack(ctx); err != nil {
+		t.Fatal(err)
+	}
+	left, err := q.ListDeadLetters(ctx, "topic", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 1 || left[0].ID != rest[0].ID {
+		t.Fatalf("replayed dead letter not removed: %+v", left)
+	}
+	if err := q.ReplayDeadLetters(ctx, "topic", first[0].ID); err == nil {
+		t.Fatal("replayed a dead letter twice")
+	}
+}
+
+func TestRedisQueueDeleteTopicKeepsDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	q := newTestRedisQueue(t, m, "c1",
+		WithReclaim(time.Hour, 1),
+		WithRetention(Retention{MaxLen: 1}))
+	ch := q.Consume(ctx, "topic")
+	for _, e := range []string{"first", "second"} {
+		if err := q.ProduceEvent(ctx, "topic", e); err != nil {
+			t.Fatal(err)
+		}
+		if n := nackUntilDeadLettered(t, ch); n != 1 {
+			t.Fatalf("delivered %d times, want 1", n)
+		}
+	}
+	if err := q.DeleteTopic(ctx, "topic"); err != nil {
+		t.Fatal(err)
+	}
+	// neither the topic's retention nor its deletion applies to them
+	dead, err := q.ListDeadLetters(ctx, "topic", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 2 {
+		t.Fatalf("got %d dead letters, want 2", len(dead))
+	}
+}
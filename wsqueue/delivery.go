@@ -0,0 +1,61 @@
+package wsqueue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Delivery is an event handed out by Queue.Consume that stays pending until
+// it is settled. Ack marks it as processed; Nack hands it back to the queue
+// so it is delivered again. Only the first call to either has an effect.
+type Delivery[T any] struct {
+	// ID identifies the entry in its topic (the stream ID for Redis).
+	ID    string
+	Event T
+
+	settle *settleOnce
+}
+
+type settleOnce struct {
+	once sync.Once
+	ack  func(ctx context.Context) error
+	nack func(ctx context.Context) error
+}
+
+func newDelivery[T any](id string, e T, ack, nack func(ctx context.Context) error) Delivery[T] {
+	return Delivery[T]{
+		ID:     id,
+		Event:  e,
+		settle: &settleOnce{ack: ack, nack: nack},
+	}
+}
+
+// Ack acknowledges the delivery so it is never redelivered.
+func (d Delivery[T]) Ack(ctx context.Context) error {
+	return d.do(ctx, true)
+}
+
+// Nack returns the delivery to the queue for redelivery.
+func (d Delivery[T]) Nack(ctx context.Context) error {
+	return d.do(ctx, false)
+}
+
+func (d Delivery[T]) do(ctx context.Context, ack bool) (err error) {
+	if d.settle == nil {
+		return fmt.Errorf("delivery %q: not consumed from a queue", d.ID)
+	}
+	settled := true
+	d.settle.once.Do(func() {
+		settled = false
+		if ack {
+			err = d.settle.ack(ctx)
+		} else {
+			err = d.settle.nack(ctx)
+		}
+	})
+	if settled {
+		return fmt.Errorf("delivery %q: already settled", d.ID)
+	}
+	return err
+}
@@ -1 +1,420 @@
 package wsqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Seann-Moser/multiws/wsmodels"
+	"github.com/google/uuid"
+	"log/slog"
+	"strconv"
+	"sync"
+)
+
+// LocalBroker holds the in-process streams shared by every LocalQueue
+// created from it. Queues only see each other's events when they share a
+// broker, so use one per process (or one per test).
+type LocalBroker struct {
+	mu     sync.Mutex
+	topics map[string]*localTopic
+	seq    uint64
+}
+
+type localTopic struct {
+	groups map[string]*localGroup
+}
+
+// localGroup is the in-memory equivalent of a Redis consumer group: every
+// group on a topic receives each event, and consumers in the same group
+// compete for them.
+type localGroup struct {
+	mu      sync.Mutex
+	pending []localEntry
+	// signal is closed and replaced whenever pending grows
+	signal chan struct{}
+	// deleted is closed when the topic is deleted, ending the group like
+	// NOGROUP ends a Redis consumer
+	deleted chan struct{}
+}
+
+type localEntry struct {
+	id    string
+	event any
+}
+
+func NewLocalBroker() *LocalBroker {
+	return &LocalBroker{
+		topics: map[string]*localTopic{},
+	}
+}
+
+// group returns the consumer group for topic, creating both if needed.
+// Like XGROUP CREATE ... $ a new group only sees events published after it
+// was created.
+func (b *LocalBroker) group(topic, group string) *localGroup {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.topics[topic]
+	if !ok {
+		t = &localTopic{groups: map[string]*localGroup{}}
+		b.topics[topic] = t
+	}
+	g, ok := t.groups[group]
+	if !ok {
+		g = &localGroup{signal: make(chan struct{}), deleted: make(chan struct{})}
+		t.groups[group] = g
+	}
+	return g
+}
+
+// publish fans e out to every group currently subscribed to topic.
+func (b *LocalBroker) publish(topic string, e any) {
+	b.mu.Lock()
+	t, ok := b.topics[topic]
+	if !ok {
+		b.mu.Unlock()
+		return
+	}
+	b.seq++
+	entry := localEntry{id: strconv.FormatUint(b.seq, 10), event: e}
+	groups := make([]*localGroup, 0, len(t.groups))
+	for _, g := range t.groups {
+		groups = append(groups, g)
+	}
+	b.mu.Unlock()
+
+	for _, g := range groups {
+		g.push(entry, false)
+	}
+}
+
+// push queues e for the group; requeued entries go to the front so they
+// are redelivered before anything newer.
+func (g *localGroup) push(e localEntry, front bool) {
+	g.mu.Lock()
+	if front {
+		g.pending = append([]localEntry{e}, g.pending...)
+	} else {
+		g.pending = append(g.pending, e)
+	}
+	close(g.signal)
+	g.signal = make(chan struct{})
+	g.mu.Unlock()
+}
+
+// errTopicDeleted is returned to consumers of a topic that was deleted.
+var errTopicDeleted = errors.New("topic deleted")
+
+// pop blocks until an event is available, done/ctx is closed or the topic
+// is deleted.
+func (g *localGroup) pop(ctx context.Context, done <-chan struct{}) (localEntry, error) {
+	for {
+		select {
+		case <-g.deleted:
+			// whatever was still queued went with the topic
+			return localEntry{}, errTopicDeleted
+		default:
+		}
+		g.mu.Lock()
+		if len(g.pending) > 0 {
+			e := g.pending[0]
+			g.pending[0] = localEntry{}
+			g.pending = g.pending[1:]
+			g.mu.Unlock()
+			return e, nil
+		}
+		signal := g.signal
+		g.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return localEntry{}, ctx.Err()
+		case <-done:
+			return localEntry{}, fmt.Errorf("queue closed")
+		case <-g.deleted:
+			return localEntry{}, errTopicDeleted
+		case <-signal:
+		}
+	}
+}
+
+// LocalQueue is an in-memory Queue with the same topic fan-out and
+// consumer-group semantics as RedisStreamQueue. It needs no external
+// services, which makes it suitable for tests and single-node deployments.
+// Values are handed over as-is, without a Codec; queues sharing a topic
+// must use the same T.
+type LocalQueue[T any] struct {
+	broker *LocalBroker
+	// how many events to buffer in the Go channel
+	channelSize int
+	group       string
+	consumer    string
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+	// deliveries handed out by Consume that are not settled yet; they are
+	// returned to their group when the queue closes.
+	inflight map[*localGroup]map[string]localEntry
+}
+
+var _ Queue[wsmodels.Event] = &LocalQueue[wsmodels.Event]{}
+
+// NewLocalQueue returns a queue backed by broker. group and consumer behave
+// like their RedisStreamQueue counterparts.
+func NewLocalQueue[T any](
+	broker *LocalBroker,
+	channelSize int, group, consumer string,
+) (Queue[T], error) {
+	if broker == nil {
+		return nil, fmt.Errorf("NewLocalQueue: broker not specified")
+	}
+	if channelSize < 0 {
+		channelSize = 0
+	}
+	if group == "" {
+		return nil, fmt.Errorf("NewLocalQueue: group not specified")
+	}
+	if consumer == "" {
+		consumer = uuid.New().String()
+		slog.Info("consumer not specified, using random consumer", "consumer", consumer)
+	}
+	return &LocalQueue[T]{
+		broker:      broker,
+		channelSize: channelSize,
+		group:       group,
+		consumer:    consumer,
+		done:        make(chan struct{}),
+		inflight:    map[*localGroup]map[string]localEntry{},
+	}, nil
+}
+
+// start runs fn in a goroutine tracked by Close. It reports false, without
+// running fn, once the queue is closed.
+func (q *LocalQueue[T]) start(fn func()) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false
+	}
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		fn()
+	}()
+	return true
+}
+
+// Produce returns a channel whose events are published to topic until ctx
+// is cancelled, the channel is closed or the queue is closed. The channel
+// belongs to the caller; events still buffered in it when the queue closes
+// are published before Close returns.
+func (q *LocalQueue[T]) Produce(ctx context.Context, topic string) chan<- T {
+	ch := make(chan T, q.channelSize)
+	q.start(func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.done:
+				for {
+					select {
+					case evt, ok := <-ch:
+						if !ok {
+							return
+						}
+						q.broker.publish(topic, evt)
+					default:
+						return
+					}
+				}
+			case evt, ok := <-ch:
+				if !ok {
+					return
+				}
+				q.broker.publish(topic, evt)
+			}
+		}
+	})
+	return ch
+}
+
+// Subscribe joins the queue's group on topic and returns its events. Each
+// event is acknowledged once it has been handed to the channel, which is
+// closed once ctx is cancelled or the queue is closed.
+func (q *LocalQueue[T]) Subscribe(ctx context.Context, topic string) <-chan T {
+	deliveries := q.Consume(ctx, topic)
+	out := make(chan T, q.channelSize)
+	started := q.start(func() {
+		defer close(out)
+		for d := range deliveries {
+			select {
+			case <-ctx.Done():
+				_ = d.Nack(context.Background())
+				return
+			case <-q.done:
+				_ = d.Nack(context.Background())
+				return
+			case out <- d.Event:
+				_ = d.Ack(ctx)
+			}
+		}
+	})
+	if !started {
+		close(out)
+	}
+	return out
+}
+
+// Consume joins the queue's group on topic and returns deliveries that must
+// be settled. Nacked deliveries, and any still unsettled when the queue is
+// closed, go back to the front of the group so another consumer gets them.
+func (q *LocalQueue[T]) Consume(ctx context.Context, topic string) <-chan Delivery[T] {
+	g := q.broker.group(topic, q.group)
+	out := make(chan Delivery[T], q.channelSize)
+	started := q.start(func() {
+		defer close(out)
+		for {
+			entry, err := g.pop(ctx, q.done)
+			if err != nil {
+				return
+			}
+			v, ok := entry.event.(T)
+			if !ok {
+				slog.Error("Consume: bad payload type", "topic", topic, "type", fmt.Sprintf("%T", entry.event))
+				continue
+			}
+			d := q.track(g, entry, v)
+			select {
+			case <-ctx.Done():
+				_ = d.Nack(context.Background())
+				return
+			case <-q.done:
+				_ = d.Nack(context.Background())
+				return
+			case out <- d:
+			}
+		}
+	})
+	if !started {
+		close(out)
+	}
+	return out
+}
+
+// track records entry as in flight and returns its Delivery carrying v.
+func (q *LocalQueue[T]) track(g *localGroup, entry localEntry, v T) Delivery[T] {
+	q.mu.Lock()
+	if q.inflight[g] == nil {
+		q.inflight[g] = map[string]localEntry{}
+	}
+	q.inflight[g][entry.id] = entry
+	q.mu.Unlock()
+
+	untrack := func() bool {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if _, ok := q.inflight[g][entry.id]; !ok {
+			// already returned to the group by Close
+			return false
+		}
+		delete(q.inflight[g], entry.id)
+		return true
+	}
+	return newDelivery(entry.id, v,
+		func(ctx context.Context) error {
+			untrack()
+			return nil
+		},
+		func(ctx context.Context) error {
+			if untrack() {
+				g.push(entry, true)
+			}
+			return nil
+		},
+	)
+}
+
+// ConsumeEvent blocks until the next event for this queue's group arrives
+// on topic, ctx is done or the queue is closed.
+func (q *LocalQueue[T]) ConsumeEvent(ctx context.Context, topic string) (T, error) {
+	var zero T
+	entry, err := q.broker.group(topic, q.group).pop(ctx, q.done)
+	if err != nil {
+		return zero, fmt.Errorf("ConsumeEvent: %w", err)
+	}
+	v, ok := entry.event.(T)
+	if !ok {
+		return zero, fmt.Errorf("ConsumeEvent: bad payload type %T", entry.event)
+	}
+	return v, nil
+}
+
+// ProduceEvent publishes e to topic synchronously.
+func (q *LocalQueue[T]) ProduceEvent(ctx context.Context, topic string, e T) error {
+	select {
+	case <-q.done:
+		return fmt.Errorf("ProduceEvent: queue closed")
+	default:
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("ProduceEvent: %w", err)
+	}
+	q.broker.publish(topic, e)
+	return nil
+}
+
+// DeleteTopic drops topic and its groups from the broker. Subscriptions to
+// it close their channels and blocked ConsumeEvent calls return an error,
+// as they do when a Redis stream is deleted.
+func (q *LocalQueue[T]) DeleteTopic(ctx context.Context, topic string) error {
+	q.broker.mu.Lock()
+	t, ok := q.broker.topics[topic]
+	delete(q.broker.topics, topic)
+	q.broker.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	for _, g := range t.groups {
+		close(g.deleted)
+	}
+	return nil
+}
+
+// Close stops every subscription started by this queue, publishes events
+// still buffered in Produce channels and returns unsettled deliveries to
+// their groups, waiting at most until ctx is done. The broker and its
+// topics are left untouched.
+func (q *LocalQueue[T]) Close(ctx context.Context) error {
+	var err error
+	q.closeOnce.Do(func() {
+		q.mu.Lock()
+		q.closed = true
+		q.mu.Unlock()
+		close(q.done)
+
+		done := make(chan struct{})
+		go func() {
+			q.wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			err = fmt.Errorf("Close: %w", ctx.Err())
+		}
+
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		for g, entries := range q.inflight {
+			for id, entry := range entries {
+				g.push(entry, true)
+				delete(entries, id)
+			}
+		}
+	})
+	return err
+}
@@ -0,0 +1,180 @@
+package wsqueue
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newTestLocalQueue(t *testing.T, b *LocalBroker, group, consumer string) Queue[string] {
+	t.Helper()
+	q, err := NewLocalQueue[string](b, 10, group, consumer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = q.Close(context.Background()) })
+	return q
+}
+
+func receive[T any](t *testing.T, ch <-chan Delivery[T]) Delivery[T] {
+	t.Helper()
+	select {
+	case d, ok := <-ch:
+		if !ok {
+			t.Fatal("channel closed")
+		}
+		return d
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for delivery")
+	}
+	panic("unreachable")
+}
+
+func expectNone[T any](t *testing.T, ch <-chan Delivery[T]) {
+	t.Helper()
+	select {
+	case d, ok := <-ch:
+		if ok {
+			t.Fatalf("unexpected delivery %v", d.Event)
+		}
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestLocalQueueFanOut(t *testing.T) {
+	ctx := context.Background()
+	b := NewLocalBroker()
+	a := newTestLocalQueue(t, b, "a", "a1")
+	c := newTestLocalQueue(t, b, "c", "c1")
+	fromA := a.Consume(ctx, "topic")
+	fromC := c.Consume(ctx, "topic")
+
+	if err := a.ProduceEvent(ctx, "topic", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	for _, ch := range []<-chan Delivery[string]{fromA, fromC} {
+		d := receive(t, ch)
+		if d.Event != "hello" {
+			t.Fatalf("got %q, want hello", d.Event)
+		}
+		if err := d.Ack(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLocalQueueGroupCompetition(t *testing.T) {
+	ctx := context.Background()
+	b := NewLocalBroker()
+	q1 := newTestLocalQueue(t, b, "g", "c1")
+	q2 := newTestLocalQueue(t, b, "g", "c2")
+	ch1 := q1.Consume(ctx, "topic")
+	ch2 := q2.Consume(ctx, "topic")
+
+	const n = 20
+	for i := 0; i < n; i++ {
+		if err := q1.ProduceEvent(ctx, "topic", "e"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seen := map[string]bool{}
+	for len(seen) < n {
+		var d Delivery[string]
+		select {
+		case d = <-ch1:
+		case d = <-ch2:
+		case <-time.After(time.Second):
+			t.Fatalf("received %d of %d events", len(seen), n)
+		}
+		if seen[d.ID] {
+			t.Fatalf("event %s delivered twice", d.ID)
+		}
+		seen[d.ID] = true
+		_ = d.Ack(ctx)
+	}
+	expectNone(t, ch1)
+	expectNone(t, ch2)
+}
+
+func TestLocalQueueNackRequeues(t *testing.T) {
+	ctx := context.Background()
+	b := NewLocalBroker()
+	q := newTestLocalQueue(t, b, "g", "c1")
+	ch := q.Consume(ctx, "topic")
+
+	for _, e := range []string{"first", "second"} {
+		if err := q.ProduceEvent(ctx, "topic", e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := receive(t, ch)
+	if d.Event != "first" {
+		t.Fatalf("got %q, want first", d.Event)
+	}
+	if err := d.Nack(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// the channel may already hold "second"; the nacked entry follows it
+	got := map[string]int{}
+	for i := 0; i < 2; i++ {
+		d := receive(t, ch)
+		got[d.Event]++
+		_ = d.Ack(ctx)
+	}
+	if got["first"] != 1 || got["second"] != 1 {
+		t.Fatalf("got %v, want first and second once each", got)
+	}
+}
+
+func TestLocalQueueCloseRequeues(t *testing.T) {
+	ctx := context.Background()
+	b := NewLocalBroker()
+	q1, err := NewLocalQueue[string](b, 0, "g", "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch1 := q1.Consume(ctx, "topic")
+	if err := q1.ProduceEvent(ctx, "topic", "pending"); err != nil {
+		t.Fatal(err)
+	}
+	// received but never settled
+	receive(t, ch1)
+	if err := q1.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	q2 := newTestLocalQueue(t, b, "g", "c2")
+	d := receive(t, q2.Consume(ctx, "topic"))
+	if d.Event != "pending" {
+		t.Fatalf("got %q, want pending", d.Event)
+	}
+}
+
+func TestLocalQueueDeleteTopic(t *testing.T) {
+	ctx := context.Background()
+	b := NewLocalBroker()
+	q := newTestLocalQueue(t, b, "g", "c1")
+	other := newTestLocalQueue(t, b, "h", "c2")
+	chs := []<-chan Delivery[string]{q.Consume(ctx, "topic"), other.Consume(ctx, "topic")}
+	if err := q.ProduceEvent(ctx, "topic", "dropped"); err != nil {
+		t.Fatal(err)
+	}
+	// leave the event queued in the groups
+	if err := q.DeleteTopic(ctx, "topic"); err != nil {
+		t.Fatal(err)
+	}
+	for _, ch := range chs {
+		for {
+			var closed bool
+			select {
+			case _, ok := <-ch:
+				closed = !ok
+			case <-time.After(time.Second):
+				t.Fatal("Consume channel not closed after DeleteTopic")
+			}
+			if closed {
+				break
+			}
+		}
+	}
+}
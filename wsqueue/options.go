@@ -0,0 +1,174 @@
+package wsqueue
+
+import (
+	"time"
+)
+
+const (
+	// defaultBlockTimeout bounds each blocking XREADGROUP. go-redis does not
+	// interrupt a read in progress when its context is cancelled, so this
+	// is also how long cancellation and Close may take to be noticed.
+	defaultBlockTimeout      = time.Second
+	defaultBatchCount        = 1
+	defaultProduceBatch      = 64
+	defaultReclaimMinIdle    = 30 * time.Second
+	defaultReclaimMaxDeliver = 5
+)
+
+// Option configures a RedisStreamQueue.
+type Option func(*redisOptions)
+
+type redisOptions struct {
+	group       string
+	consumer    string
+	channelSize int
+	// blockTimeout and batchCount shape each XREADGROUP
+	blockTimeout time.Duration
+	batchCount   int64
+	// produceBatch and produceFlush control how Produce coalesces XADDs
+	produceBatch int
+	produceFlush time.Duration
+
+	reclaimMinIdle    time.Duration
+	reclaimMaxDeliver int64
+	deadLetterTopic   func(topic string) string
+	retention         Retention
+	topicRetention    map[string]Retention
+	// deadLetterRetention applies to dead-letter streams instead of the
+	// retention of their topic
+	deadLetterRetention Retention
+	// codec is a Codec[T] for the queue's T, checked by the constructor
+	codec any
+}
+
+func defaultRedisOptions() redisOptions {
+	return redisOptions{
+		blockTimeout:      defaultBlockTimeout,
+		batchCount:        defaultBatchCount,
+		produceBatch:      defaultProduceBatch,
+		reclaimMinIdle:    defaultReclaimMinIdle,
+		reclaimMaxDeliver: defaultReclaimMaxDeliver,
+		deadLetterTopic:   DeadLetterTopic,
+	}
+}
+
+// WithCodec sets how values are serialized into stream entries. The
+// default is JSONCodec. T must match the queue's type parameter.
+func WithCodec[T any](c Codec[T]) Option {
+	return func(o *redisOptions) {
+		o.codec = c
+	}
+}
+
+// WithGroup sets the consumer group the queue reads with. Every group sees
+// every event on a topic. Required.
+func WithGroup(group string) Option {
+	return func(o *redisOptions) {
+		o.group = group
+	}
+}
+
+// WithConsumer sets the name of this consumer within its group, e.g. a pod
+// or host name. A random name is used when it is not set.
+func WithConsumer(consumer string) Option {
+	return func(o *redisOptions) {
+		o.consumer = consumer
+	}
+}
+
+// WithChannelSize sets how many events are buffered in the Go channels
+// returned by Subscribe, Consume and Produce.
+func WithChannelSize(size int) Option {
+	return func(o *redisOptions) {
+		o.channelSize = max(size, 0)
+	}
+}
+
+// WithBlockTimeout bounds how long a single read waits on Redis before
+// checking for cancellation again. A read in progress is not interrupted,
+// so Close takes up to this long. The default is 1s.
+func WithBlockTimeout(d time.Duration) Option {
+	return func(o *redisOptions) {
+		if d >= time.Millisecond {
+			o.blockTimeout = d
+		}
+	}
+}
+
+// WithBatchCount sets how many entries a single read may return. Larger
+// batches cut round trips for busy topics.
+func WithBatchCount(n int64) Option {
+	return func(o *redisOptions) {
+		if n > 0 {
+			o.batchCount = n
+		}
+	}
+}
+
+// WithProduceBatch controls how the channel returned by Produce writes to
+// Redis. Up to maxBatch queued events are sent as one pipeline of XADDs.
+// With a flushInterval > 0 the producer waits up to that long for a batch
+// to fill, trading latency for throughput; otherwise only events that are
+// already queued are coalesced. A maxBatch of 1 disables batching.
+func WithProduceBatch(maxBatch int, flushInterval time.Duration) Option {
+	return func(o *redisOptions) {
+		o.produceBatch = max(maxBatch, 1)
+		o.produceFlush = max(flushInterval, 0)
+	}
+}
+
+// WithReclaim configures pending-entry recovery. Entries that have been
+// pending in the group for at least minIdle (for example because the
+// consumer holding them crashed) are claimed by a live consumer and
+// redelivered. Once an entry has been delivered maxDeliveries times,
+// whether it went idle or was nacked, it is moved to the dead-letter stream
+// (see WithDeadLetter) instead. Entries a receiver is still working on are
+// kept fresh while it does, so a slow receiver does not lose them. A
+// minIdle <= 0 disables recovery of idle entries; maxDeliveries <= 0 never
+// dead-letters.
+func WithReclaim(minIdle time.Duration, maxDeliveries int64) Option {
+	return func(o *redisOptions) {
+		o.reclaimMinIdle = minIdle
+		o.reclaimMaxDeliver = maxDeliveries
+	}
+}
+
+// WithDeadLetter sets the stream that undecodable or repeatedly failing
+// entries of a topic are moved to. The default is DeadLetterTopic. A nil
+// func disables dead lettering, dropping such entries instead.
+func WithDeadLetter(topic func(topic string) string) Option {
+	return func(o *redisOptions) {
+		o.deadLetterTopic = topic
+	}
+}
+
+// WithRetention sets the retention policy applied to every topic without a
+// more specific WithTopicRetention. Dead-letter streams are exempt, see
+// WithDeadLetterRetention.
+func WithRetention(r Retention) Option {
+	return func(o *redisOptions) {
+		o.retention = r
+	}
+}
+
+// WithTopicRetention sets the retention policy for topics starting with
+// prefix, e.g. "ses_" for session streams. The longest matching prefix
+// wins.
+func WithTopicRetention(prefix string, r Retention) Option {
+	return func(o *redisOptions) {
+		if o.topicRetention == nil {
+			o.topicRetention = map[string]Retention{}
+		}
+		o.topicRetention[prefix] = r
+	}
+}
+
+// WithDeadLetterRetention sets the retention policy of dead-letter streams.
+// They ignore WithRetention and WithTopicRetention, even when their name
+// matches a prefix, and by default keep every entry until it is replayed or
+// removed.
+func WithDeadLetterRetention(r Retention) Option {
+	return func(o *redisOptions) {
+		o.deadLetterRetention = r
+	}
+}
@@ -2,15 +2,31 @@ package wsqueue
 
 import (
 	"context"
-	"github.com/Seann-Moser/multiws/wsmodels"
 )
 
+// Queue carries values of type T between producers and consumers on named
+// topics.
 type Queue[T any] interface {
-	Subscribe(ctx context.Context, topic string) <-chan wsmodels.Event
-	Produce(ctx context.Context, topic string) chan<- wsmodels.Event
+	// Subscribe returns the events published to topic. Each event is
+	// acknowledged once it has been handed to the channel.
+	Subscribe(ctx context.Context, topic string) <-chan T
+	// Consume returns the events published to topic as deliveries that stay
+	// pending until they are acknowledged, giving at-least-once delivery.
+	Consume(ctx context.Context, topic string) <-chan Delivery[T]
+	Produce(ctx context.Context, topic string) chan<- T
 
-	ConsumeEvent(ctx context.Context, topic string) wsmodels.Event
-	ProduceEvent(ctx context.Context, topic string, e wsmodels.Event)
+	// ConsumeEvent blocks until a single event is read from topic or ctx
+	// is done.
+	ConsumeEvent(ctx context.Context, topic string) (T, error)
+	// ProduceEvent synchronously publishes e to topic.
+	ProduceEvent(ctx context.Context, topic string, e T) error
 
-	Close()
+	// DeleteTopic drops topic and everything queued on it, for every
+	// consumer group. It may still be called after Close.
+	DeleteTopic(ctx context.Context, topic string) error
+
+	// Close stops the queue's subscriptions and flushes pending producer
+	// events, waiting at most until ctx is done. It is safe to call more
+	// than once.
+	Close(ctx context.Context) error
 }
@@ -0,0 +1,180 @@
+package wsqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"log/slog"
+	"time"
+)
+
+// reclaimBatch caps how many pending entries are inspected per pass.
+const reclaimBatch = 100
+
+// reclaim moves entries that have sat idle in the group's pending entries
+// list for at least reclaimMinIdle over to this consumer and returns them
+// for redelivery. Entries that have already been delivered
+// reclaimMaxDeliver times are dead-lettered instead. Entries st handed out
+// and are still waiting on the receiver are left alone: a slow receiver is
+// not a failed delivery. Consumers on other pods keep theirs from going
+// idle, see refresh.
+func (q *RedisStreamQueue[T]) reclaim(ctx context.Context, topic string, st *consumeState) ([]redis.XMessage, error) {
+	if q.opts.reclaimMinIdle <= 0 {
+		return nil, nil
+	}
+	pending, err := q.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: topic,
+		Group:  q.group,
+		Idle:   q.opts.reclaimMinIdle,
+		Start:  "-",
+		End:    "+",
+		Count:  reclaimBatch,
+	}).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("XPendingExt: %w", err)
+	}
+	ids := make([]string, 0, len(pending))
+	for _, p := range pending {
+		if p.Consumer == q.consumer && st.inFlight(p.ID) {
+			continue
+		}
+		if q.opts.reclaimMaxDeliver > 0 && p.RetryCount >= q.opts.reclaimMaxDeliver {
+			if err := q.claimDeadLetter(ctx, topic, p); err != nil {
+				slog.Error("reclaim: dead letter", "err", err, "id", p.ID)
+			}
+			continue
+		}
+		ids = append(ids, p.ID)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	// MinIdle makes the claim lose to a consumer that touched them since
+	msgs, err := q.client.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   topic,
+		Group:    q.group,
+		Consumer: q.consumer,
+		MinIdle:  q.opts.reclaimMinIdle,
+		Messages: ids,
+	}).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("XClaim: %w", err)
+	}
+	return msgs, nil
+}
+
+// claimNacked claims the nacked entries ids back to this consumer for
+// redelivery. Entries already delivered reclaimMaxDeliver times are
+// dead-lettered instead, so a message that always fails does not loop.
+func (q *RedisStreamQueue[T]) claimNacked(ctx context.Context, topic string, ids []string) ([]redis.XMessage, error) {
+	var counts []*redis.XPendingExtCmd
+	if q.opts.reclaimMaxDeliver > 0 {
+		counts = make([]*redis.XPendingExtCmd, len(ids))
+		_, err := q.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+			for i, id := range ids {
+				counts[i] = p.XPendingExt(ctx, &redis.XPendingExtArgs{
+					Stream: topic,
+					Group:  q.group,
+					Start:  id,
+					End:    id,
+					Count:  1,
+				})
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return nil, fmt.Errorf("XPendingExt: %w", err)
+		}
+	}
+	retry := ids[:0:0]
+	for i, id := range ids {
+		if counts != nil {
+			if p := counts[i].Val(); len(p) == 1 && p[0].RetryCount >= q.opts.reclaimMaxDeliver {
+				if err := q.nackDeadLetter(ctx, topic, p[0]); err != nil {
+					slog.Error("claimNacked: dead letter", "err", err, "id", id)
+				}
+				continue
+			}
+		}
+		retry = append(retry, id)
+	}
+	if len(retry) == 0 {
+		return nil, nil
+	}
+	msgs, err := q.client.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   topic,
+		Group:    q.group,
+		Consumer: q.consumer,
+		Messages: retry,
+	}).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("XClaim: %w", err)
+	}
+	return msgs, nil
+}
+
+// nackDeadLetter moves the nacked entry p, which this consumer still owns,
+// to the dead-letter stream.
+func (q *RedisStreamQueue[T]) nackDeadLetter(ctx context.Context, topic string, p redis.XPendingExt) error {
+	msgs, err := q.client.XRangeN(ctx, topic, p.ID, p.ID, 1).Result()
+	if err != nil {
+		return fmt.Errorf("XRange: %w", err)
+	}
+	msg := redis.XMessage{ID: p.ID}
+	if len(msgs) == 1 {
+		msg = msgs[0]
+	}
+	return q.deadLetter(ctx, topic, msg, p.Consumer, p.RetryCount, "max deliveries exceeded")
+}
+
+// claimDeadLetter claims the pending entry p and moves it to the
+// dead-letter stream.
+func (q *RedisStreamQueue[T]) claimDeadLetter(ctx context.Context, topic string, p redis.XPendingExt) error {
+	// claiming first makes sure no other consumer is dead-lettering it too
+	msgs, err := q.client.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   topic,
+		Group:    q.group,
+		Consumer: q.consumer,
+		MinIdle:  q.opts.reclaimMinIdle,
+		Messages: []string{p.ID},
+	}).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("XClaim: %w", err)
+	}
+	if len(msgs) == 0 {
+		// claimed by someone else in the meantime
+		return nil
+	}
+	return q.deadLetter(ctx, topic, msgs[0], p.Consumer, p.RetryCount, "max deliveries exceeded")
+}
+
+// refresh claims the entries st handed out back to this consumer every
+// third of reclaimMinIdle, resetting their idle time, so the reclaim passes
+// of other consumers leave them alone while a slow receiver still holds
+// them. It returns once ctx is done.
+func (q *RedisStreamQueue[T]) refresh(ctx context.Context, topic string, st *consumeState) {
+	ticker := time.NewTicker(max(q.opts.reclaimMinIdle/3, time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ids := st.held()
+		if len(ids) == 0 {
+			continue
+		}
+		// JUSTID leaves the delivery count alone
+		err := q.client.XClaimJustID(ctx, &redis.XClaimArgs{
+			Stream:   topic,
+			Group:    q.group,
+			Consumer: q.consumer,
+			Messages: ids,
+		}).Err()
+		if err != nil && !errors.Is(err, redis.Nil) && ctx.Err() == nil {
+			slog.Error("Consume: refresh", "err", err)
+		}
+	}
+}
@@ -2,31 +2,197 @@ package wsqueue
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/Seann-Moser/multiws/wsmodels"
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 	"log/slog"
 	"strings"
+	"sync"
+	"time"
 )
 
 type RedisStreamQueue[T any] struct {
-	client *redis.Client
+	client redis.UniversalClient
+	// whether Close should close client, i.e. the queue created it
+	ownsClient bool
 	// how many events to buffer in the Go channel
 	channelSize int
 	group       string
 	consumer    string
+	codec       Codec[T]
+	opts        redisOptions
+
+	// closing is cancelled by Close; every reader and producer started by
+	// the queue is tracked in wg so Close can wait for them.
+	closing   context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	mu        sync.Mutex
+	closed    bool
+	wg        sync.WaitGroup
+	// drainCtx bounds the final flush of producers, set by Close before
+	// closing is cancelled
+	drainCtx context.Context
+}
+
+// ConsumeEvent reads a single new event from topic for this queue's group,
+// blocking until one arrives or ctx is done. The event is acknowledged
+// before it is returned.
+func (q *RedisStreamQueue[T]) ConsumeEvent(ctx context.Context, topic string) (T, error) {
+	var zero T
+	ctx, stop := q.scope(ctx)
+	defer stop()
+	if err := q.ensureGroup(ctx, topic); err != nil {
+		return zero, fmt.Errorf("ConsumeEvent: %w", err)
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return zero, fmt.Errorf("ConsumeEvent: %w", err)
+		}
+		block := q.opts.blockTimeout
+		if deadline, ok := ctx.Deadline(); ok {
+			block = min(block, time.Until(deadline))
+			// BLOCK has millisecond resolution and 0 means forever
+			if block < time.Millisecond {
+				return zero, fmt.Errorf("ConsumeEvent: %w", context.DeadlineExceeded)
+			}
+		}
+		streams, err := q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    q.group,
+			Consumer: q.consumer,
+			Streams:  []string{topic, ">"},
+			Count:    1,
+			Block:    block,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			// go-redis checks ctx before sending, not while blocked, so a
+			// cancelled ctx shows up as an error of the next read
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return zero, fmt.Errorf("ConsumeEvent: %w", ctxErr)
+			}
+			return zero, fmt.Errorf("ConsumeEvent: XReadGroup: %w", err)
+		}
+		if len(streams) == 0 || len(streams[0].Messages) == 0 {
+			continue
+		}
+		msg := streams[0].Messages[0]
+		evt, err := q.decode(msg)
+		if err != nil {
+			q.reject(ctx, topic, msg, err)
+			return zero, fmt.Errorf("ConsumeEvent: %w", err)
+		}
+		if err := q.client.XAck(ctx, topic, q.group, msg.ID).Err(); err != nil {
+			return zero, fmt.Errorf("ConsumeEvent: XAck: %w", err)
+		}
+		return evt, nil
+	}
+}
+
+// ProduceEvent appends e to the topic stream and waits for Redis to accept
+// it.
+func (q *RedisStreamQueue[T]) ProduceEvent(ctx context.Context, topic string, e T) error {
+	if err := q.add(ctx, topic, e); err != nil {
+		return fmt.Errorf("ProduceEvent: %w", err)
+	}
+	return nil
+}
+
+// ensureGroup creates the consumer group on topic (and the stream itself)
+// if it does not exist yet. New groups start reading new messages only.
+func (q *RedisStreamQueue[T]) ensureGroup(ctx context.Context, topic string) error {
+	if err := q.client.XGroupCreateMkStream(ctx, topic, q.group, "$").Err(); err != nil {
+		// ignore BUSYGROUP if already created
+		if !strings.Contains(err.Error(), "BUSYGROUP") {
+			return fmt.Errorf("XGroupCreateMkStream: %w", err)
+		}
+	}
+	return nil
+}
+
+// add marshals evt and appends it to the topic stream, trimming it as
+// configured by WithRetention.
+func (q *RedisStreamQueue[T]) add(ctx context.Context, topic string, evt T) error {
+	data, err := q.codec.Marshal(evt)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	// XAdd will append to the stream named by topic
+	return q.xadd(ctx, q.opts.retentionFor(topic), topic, map[string]interface{}{"data": data})
+}
+
+// collect gathers first and whatever else is queued on ch into one batch
+// of at most produceBatch events, waiting up to produceFlush for it to fill.
+func (q *RedisStreamQueue[T]) collect(first T, ch <-chan T) []T {
+	batch := []T{first}
+	var flush <-chan time.Time
+	if q.opts.produceFlush > 0 {
+		t := time.NewTimer(q.opts.produceFlush)
+		defer t.Stop()
+		flush = t.C
+	}
+	for len(batch) < q.opts.produceBatch {
+		if flush == nil {
+			select {
+			case evt, ok := <-ch:
+				if !ok {
+					return batch
+				}
+				batch = append(batch, evt)
+			default:
+				return batch
+			}
+			continue
+		}
+		select {
+		case evt, ok := <-ch:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, evt)
+		case <-flush:
+			return batch
+		}
+	}
+	return batch
 }
 
-func (q *RedisStreamQueue[T]) ConsumeEvent(ctx context.Context, topic string) wsmodels.Event {
-	//TODO implement me
-	panic("implement me")
+// addBatch marshals evts and appends them to the topic stream in one
+// pipeline. Events that fail to marshal are logged and skipped.
+func (q *RedisStreamQueue[T]) addBatch(ctx context.Context, topic string, evts []T) error {
+	if len(evts) == 1 {
+		return q.add(ctx, topic, evts[0])
+	}
+	batch := make([]map[string]interface{}, 0, len(evts))
+	for _, evt := range evts {
+		data, err := q.codec.Marshal(evt)
+		if err != nil {
+			slog.Error("addBatch: marshal", "err", err)
+			continue
+		}
+		batch = append(batch, map[string]interface{}{"data": data})
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	return q.xaddBatch(ctx, topic, batch)
 }
 
-func (q *RedisStreamQueue[T]) ProduceEvent(ctx context.Context, topic string, e wsmodels.Event) {
-	//TODO implement me
-	panic("implement me")
+// decode extracts the value stored in a stream entry.
+func (q *RedisStreamQueue[T]) decode(msg redis.XMessage) (T, error) {
+	raw, ok := msg.Values["data"].(string)
+	if !ok {
+		var zero T
+		return zero, fmt.Errorf("bad payload type %T", msg.Values["data"])
+	}
+	v, err := q.codec.Unmarshal([]byte(raw))
+	if err != nil {
+		return v, fmt.Errorf("unmarshal: %w", err)
+	}
+	return v, nil
 }
 
 // NewRedisStreamQueue returns a queue that speaks Redis Streams.
@@ -34,62 +200,178 @@ func (q *RedisStreamQueue[T]) ProduceEvent(ctx context.Context, topic string, e
 func NewRedisQueue[T any](
 	addr, password string,
 	db, channelSize int, groupBox, consumer string,
+	opts ...Option,
 ) (Queue[T], error) {
-	if channelSize < 0 {
-		channelSize = 0
-	}
 	client := redis.NewClient(&redis.Options{
 		Addr:     addr,
 		Password: password,
 		DB:       db,
 	})
+	opts = append([]Option{
+		WithChannelSize(channelSize),
+		WithGroup(groupBox),
+		WithConsumer(consumer),
+	}, opts...)
+	q, err := newRedisQueue[T](client, opts...)
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+	q.ownsClient = true
+	return q, nil
+}
+
+// NewRedisQueueFromClient returns a queue that speaks Redis Streams over an
+// existing client, so one connection pool (standalone, Sentinel or Cluster)
+// can be shared by many queues. The client is not closed by Close.
+func NewRedisQueueFromClient[T any](client redis.UniversalClient, opts ...Option) (Queue[T], error) {
+	return newRedisQueue[T](client, opts...)
+}
+
+func newRedisQueue[T any](client redis.UniversalClient, opts ...Option) (*RedisStreamQueue[T], error) {
+	if client == nil {
+		return nil, fmt.Errorf("NewRedisStreamQueue: client not specified")
+	}
+	o := defaultRedisOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
 	if err := client.Ping(context.Background()).Err(); err != nil {
 		slog.Error("NewRedisStreamQueue: redis", "err", err)
 		return nil, fmt.Errorf("NewRedisStreamQueue: redis:%w", err)
 	}
-	if groupBox == "" {
+	if o.group == "" {
 		return nil, fmt.Errorf("NewRedisStreamQueue: groupBox not specified")
 	}
-	if consumer == "" {
-		consumer = uuid.New().String()
-		slog.Info("consumer not specified, using random consumer", "consumer", consumer)
+	if o.consumer == "" {
+		o.consumer = uuid.New().String()
+		slog.Info("consumer not specified, using random consumer", "consumer", o.consumer)
+	}
+	var codec Codec[T] = JSONCodec[T]{}
+	if o.codec != nil {
+		c, ok := o.codec.(Codec[T])
+		if !ok {
+			return nil, fmt.Errorf("NewRedisStreamQueue: codec %T does not encode %T", o.codec, *new(T))
+		}
+		codec = c
 	}
+	closing, cancel := context.WithCancel(context.Background())
 	return &RedisStreamQueue[T]{
 		client:      client,
-		channelSize: channelSize,
-		group:       groupBox,
-		consumer:    consumer,
+		channelSize: o.channelSize,
+		group:       o.group,
+		consumer:    o.consumer,
+		codec:       codec,
+		opts:        o,
+		closing:     closing,
+		cancel:      cancel,
 	}, nil
 }
 
+// scope returns a context that is also cancelled when the queue closes.
+func (q *RedisStreamQueue[T]) scope(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(q.closing, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// start runs fn in a goroutine tracked by Close. It reports false, without
+// running fn, once the queue is closed.
+func (q *RedisStreamQueue[T]) start(fn func()) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false
+	}
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		fn()
+	}()
+	return true
+}
+
+// releaseConsumer removes this consumer from the group on topic once it
+// holds no pending entries. Consumers that still own entries are kept so
+// they can be redelivered or reclaimed.
+func (q *RedisStreamQueue[T]) releaseConsumer(topic string) {
+	ctx, cancel := context.WithTimeout(context.Background(), q.opts.blockTimeout)
+	defer cancel()
+	pending, err := q.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream:   topic,
+		Group:    q.group,
+		Start:    "-",
+		End:      "+",
+		Count:    1,
+		Consumer: q.consumer,
+	}).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		slog.Error("releaseConsumer: XPendingExt", "err", err)
+		return
+	}
+	if len(pending) > 0 {
+		return
+	}
+	if err := q.client.XGroupDelConsumer(ctx, topic, q.group, q.consumer).Err(); err != nil {
+		slog.Error("releaseConsumer: XGroupDelConsumer", "err", err)
+	}
+}
+
 // Produce pushes events into the given stream (topic).  Returns
-// a Go channel you can send into.
+// a Go channel you can send into. Events already queued on the channel are
+// coalesced into pipelined XADDs, see WithProduceBatch.
+//
+// The channel belongs to the caller, who may close it when done. Producing
+// stops when ctx is cancelled; when the queue is closed, events still
+// buffered in the channel are flushed first.
 func (q *RedisStreamQueue[T]) Produce(
 	ctx context.Context,
 	topic string,
-) chan<- wsmodels.Event {
-	ch := make(chan wsmodels.Event, q.channelSize)
-	go func() {
-		defer close(ch)
+) chan<- T {
+	ch := make(chan T, q.channelSize)
+	q.start(func() {
 		defer fmt.Println("finished produce")
-		for evt := range ch {
-			data, err := json.Marshal(evt)
-			if err != nil {
-				slog.Error("Produce: marshal", "err", err)
-				continue
-			}
-			// XAdd will append to the stream named by topic
-			if _, err := q.client.XAdd(ctx, &redis.XAddArgs{
-				Stream: topic,
-				Values: map[string]interface{}{"data": data},
-			}).Result(); err != nil {
-				slog.Error("Produce: XAdd", "err", err)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.closing.Done():
+				q.drain(topic, ch)
+				return
+			case evt, ok := <-ch:
+				if !ok {
+					return
+				}
+				if err := q.addBatch(ctx, topic, q.collect(evt, ch)); err != nil {
+					slog.Error("Produce", "err", err)
+				}
 			}
 		}
-	}()
+	})
 	return ch
 }
 
+// drain flushes the events left in ch once the queue is closing.
+func (q *RedisStreamQueue[T]) drain(topic string, ch <-chan T) {
+	for {
+		select {
+		case evt, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := q.addBatch(q.drainCtx, topic, q.collect(evt, ch)); err != nil {
+				slog.Error("Produce: drain", "err", err)
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
 // Subscribe creates (if needed) and then joins a consumer-group on the given
 // stream (topic).  Each group sees every message; within a group multiple
 // consumers can share load, but each group gets all messages.
@@ -97,70 +379,296 @@ func (q *RedisStreamQueue[T]) Produce(
 //   - group:   a logical fan-out channel (e.g. "notifications")
 //   - consumer: a unique name for *this* consumer (e.g. pod-id, host name)
 //
-// It returns a Go channel of events; you must ack each message yourself
-// by returning nil (ACK) or requeueing by returning an error.
+// It returns a Go channel of events. Each message is acknowledged as soon
+// as it is sent into the channel; use Consume to acknowledge it yourself.
+// The channel is closed once ctx is cancelled or the queue is closed, after
+// which the consumer is removed from the group if it has nothing pending.
 func (q *RedisStreamQueue[T]) Subscribe(
 	ctx context.Context,
 	topic string,
-) <-chan wsmodels.Event {
+) <-chan T {
 	// ensure the group exists (start reading new messages)
-	if err := q.client.XGroupCreateMkStream(ctx, topic, q.group, "$").Err(); err != nil {
-		// ignore BUSYGROUP if already created
-		if !strings.Contains(err.Error(), "BUSYGROUP") {
-			slog.Error("Subscribe: XGroupCreateMkStream", "err", err)
-			return nil
-		}
+	if err := q.ensureGroup(ctx, topic); err != nil {
+		slog.Error("Subscribe", "err", err)
+		return nil
 	}
-	defer q.client.XGroupDelConsumer(context.Background(), topic, q.group, q.consumer)
 
-	out := make(chan wsmodels.Event, q.channelSize)
-	go func() {
+	ctx, stop := q.scope(ctx)
+	out := make(chan T, q.channelSize)
+	started := q.start(func() {
 		defer fmt.Println("finished subscribe")
+		defer q.releaseConsumer(topic)
+		defer stop()
 		defer close(out)
-		for {
-			// read one message at a time, block indefinitely
+		for ctx.Err() == nil {
+			// read up to batchCount messages, waiting at most blockTimeout
 			streams, err := q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
 				Group:    q.group,
 				Consumer: q.consumer,
 				Streams:  []string{topic, ">"},
-				Count:    1,
-				Block:    0,
+				Count:    q.opts.batchCount,
+				Block:    q.opts.blockTimeout,
 			}).Result()
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
 			if err != nil {
-				slog.Error("Subscribe: XReadGroup", "err", err)
+				if ctx.Err() == nil {
+					slog.Error("Subscribe: XReadGroup", "err", err)
+				}
 				return
 			}
 			for _, msg := range streams[0].Messages {
-				raw, ok := msg.Values["data"].(string)
-				if !ok {
-					slog.Error("Subscribe: bad payload type", "values", msg.Values)
-					// ack so we don’t loop forever
-					q.client.XAck(ctx, topic, q.group, msg.ID)
+				evt, err := q.decode(msg)
+				if err != nil {
+					// dead letter so broken messages don’t block
+					q.reject(ctx, topic, msg, err)
 					continue
 				}
 
-				var evt wsmodels.Event
-				if err := json.Unmarshal([]byte(raw), &evt); err != nil {
-					slog.Error("Subscribe: unmarshal", "err", err)
-					// ack so broken messages don’t block
-					q.client.XAck(ctx, topic, q.group, msg.ID)
-					continue
+				select {
+				case <-ctx.Done():
+					// left pending, so it is redelivered later
+					return
+				case out <- evt:
 				}
-
-				out <- evt
 				// ACK immediately after sending into the channel:
 				if err := q.client.XAck(ctx, topic, q.group, msg.ID).Err(); err != nil {
 					slog.Error("Subscribe: XAck", "err", err)
 				}
 			}
 		}
-	}()
+	})
+	if !started {
+		stop()
+		close(out)
+	}
 	return out
 }
 
-// Close the Redis client
-func (q *RedisStreamQueue[T]) Close() {
-	if err := q.client.Close(); err != nil {
-		slog.Error("Close: redis", "err", err)
+// Consume joins the consumer group on topic like Subscribe, but leaves every
+// message in the group's pending entries list until its Delivery is settled.
+// Ack issues XACK; Nack claims the entry back to this consumer so it is
+// redelivered on the next read. Entries still pending for this consumer
+// from an earlier run are delivered first, and entries abandoned by other
+// consumers of the group are reclaimed as configured by WithReclaim.
+//
+// The channel is closed once ctx is cancelled or the queue is closed.
+// Deliveries that were never settled stay pending for redelivery.
+func (q *RedisStreamQueue[T]) Consume(
+	ctx context.Context,
+	topic string,
+) <-chan Delivery[T] {
+	if err := q.ensureGroup(ctx, topic); err != nil {
+		slog.Error("Consume", "err", err)
+		return nil
+	}
+
+	ctx, stop := q.scope(ctx)
+	out := make(chan Delivery[T], q.channelSize)
+	st := &consumeState{start: "0", lastReclaim: time.Now()}
+	started := q.start(func() {
+		defer q.releaseConsumer(topic)
+		defer stop()
+		defer close(out)
+		for ctx.Err() == nil {
+			msgs, err := q.read(ctx, topic, st)
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Error("Consume: read", "err", err)
+				}
+				return
+			}
+			for _, msg := range msgs {
+				evt, err := q.decode(msg)
+				if err != nil {
+					// dead letter so broken messages don’t block
+					q.reject(ctx, topic, msg, err)
+					continue
+				}
+				id := msg.ID
+				st.handOut(id)
+				d := newDelivery(id, evt,
+					func(ctx context.Context) error {
+						// settled only once acked, or reclaim could take it
+						defer st.settle(id)
+						return q.client.XAck(ctx, topic, q.group, id).Err()
+					},
+					func(ctx context.Context) error {
+						st.settle(id)
+						st.nack(id)
+						return nil
+					},
+				)
+				select {
+				case <-ctx.Done():
+					return
+				case out <- d:
+				}
+			}
+		}
+	})
+	if !started {
+		stop()
+		close(out)
+		return out
 	}
+	if q.opts.reclaimMinIdle > 0 {
+		q.start(func() { q.refresh(ctx, topic, st) })
+	}
+	return out
+}
+
+// consumeState tracks where a Consume loop is reading from.
+type consumeState struct {
+	// "0" (or the last pending ID seen) while replaying this consumer's own
+	// pending entries, ">" once only new entries are read
+	start       string
+	lastReclaim time.Time
+
+	// ids nacked by the receiver, claimed back before reading anything new
+	mu    sync.Mutex
+	retry []string
+	// ids handed out and not settled yet, which reclaim must not steal
+	// from a slow receiver
+	inflight map[string]struct{}
+}
+
+func (st *consumeState) nack(id string) {
+	st.mu.Lock()
+	st.retry = append(st.retry, id)
+	st.mu.Unlock()
+}
+
+func (st *consumeState) handOut(id string) {
+	st.mu.Lock()
+	if st.inflight == nil {
+		st.inflight = map[string]struct{}{}
+	}
+	st.inflight[id] = struct{}{}
+	st.mu.Unlock()
+}
+
+func (st *consumeState) settle(id string) {
+	st.mu.Lock()
+	delete(st.inflight, id)
+	st.mu.Unlock()
+}
+
+// held returns the ids handed out and not settled yet.
+func (st *consumeState) held() []string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	ids := make([]string, 0, len(st.inflight))
+	for id := range st.inflight {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (st *consumeState) inFlight(id string) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	_, ok := st.inflight[id]
+	return ok
+}
+
+// read returns the next batch for a Consume loop: reclaimed entries when a
+// reclaim pass is due, then nacked entries, then this consumer's own
+// pending entries and finally new entries.
+func (q *RedisStreamQueue[T]) read(ctx context.Context, topic string, st *consumeState) ([]redis.XMessage, error) {
+	if q.opts.reclaimMinIdle > 0 && time.Since(st.lastReclaim) >= q.opts.reclaimMinIdle {
+		st.lastReclaim = time.Now()
+		msgs, err := q.reclaim(ctx, topic, st)
+		if err != nil && ctx.Err() == nil {
+			// a failed pass is retried after the next interval
+			slog.Error("Consume: reclaim", "err", err)
+		}
+		if len(msgs) > 0 {
+			return msgs, nil
+		}
+	}
+
+	st.mu.Lock()
+	ids := st.retry
+	st.retry = nil
+	st.mu.Unlock()
+	if len(ids) > 0 {
+		return q.claimNacked(ctx, topic, ids)
+	}
+
+	if st.start != ">" {
+		// pending history never blocks
+		streams, err := q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    q.group,
+			Consumer: q.consumer,
+			Streams:  []string{topic, st.start},
+			Count:    q.opts.batchCount,
+			Block:    -1,
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(streams) == 0 || len(streams[0].Messages) == 0 {
+			st.start = ">"
+			return nil, nil
+		}
+		msgs := streams[0].Messages
+		// advance past the pending entries already handed out
+		st.start = msgs[len(msgs)-1].ID
+		return msgs, nil
+	}
+
+	streams, err := q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    q.group,
+		Consumer: q.consumer,
+		Streams:  []string{topic, ">"},
+		Count:    q.opts.batchCount,
+		Block:    q.opts.blockTimeout,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(streams) == 0 {
+		return nil, nil
+	}
+	return streams[0].Messages, nil
+}
+
+// Close stops every subscription started by the queue, flushes events still
+// buffered in Produce channels and waits for both, bounded by ctx. The Redis
+// client is closed afterwards, unless it was passed to
+// NewRedisQueueFromClient. Calling Close more than once is a no-op.
+func (q *RedisStreamQueue[T]) Close(ctx context.Context) error {
+	var err error
+	q.closeOnce.Do(func() {
+		q.mu.Lock()
+		q.closed = true
+		q.mu.Unlock()
+		q.drainCtx = ctx
+		q.cancel()
+
+		done := make(chan struct{})
+		go func() {
+			q.wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			err = fmt.Errorf("Close: %w", ctx.Err())
+		}
+
+		if !q.ownsClient {
+			return
+		}
+		if cerr := q.client.Close(); cerr != nil {
+			slog.Error("Close: redis", "err", cerr)
+			err = errors.Join(err, fmt.Errorf("Close: redis: %w", cerr))
+		}
+	})
+	return err
 }
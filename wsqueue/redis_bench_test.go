@@ -0,0 +1,89 @@
+package wsqueue
+
+import (
+	"context"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"testing"
+	"time"
+)
+
+type benchEvent struct {
+	Message string
+}
+
+func newBenchQueue(b *testing.B, opts ...Option) (Queue[benchEvent], *redis.Client) {
+	b.Helper()
+	m := miniredis.RunT(b)
+	client := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	b.Cleanup(func() { _ = client.Close() })
+	q, err := NewRedisQueueFromClient[benchEvent](client, append([]Option{
+		WithGroup("bench"),
+		WithConsumer("c1"),
+		WithChannelSize(1024),
+	}, opts...)...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { _ = q.Close(context.Background()) })
+	return q, client
+}
+
+// BenchmarkProduce compares writing each event with its own XADD against
+// pipelining the events queued on the Produce channel.
+func BenchmarkProduce(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		opt  Option
+	}{
+		{"unbatched", WithProduceBatch(1, 0)},
+		{"batched", WithProduceBatch(defaultProduceBatch, 0)},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			ctx := context.Background()
+			q, client := newBenchQueue(b, bc.opt)
+			produce := q.Produce(ctx, "bench")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				produce <- benchEvent{Message: "x"}
+			}
+			for client.XLen(ctx, "bench").Val() < int64(b.N) {
+				time.Sleep(100 * time.Microsecond)
+			}
+		})
+	}
+}
+
+// BenchmarkConsume compares reading one entry per XREADGROUP against the
+// batches set with WithBatchCount.
+func BenchmarkConsume(b *testing.B) {
+	for _, count := range []int64{1, 100} {
+		b.Run(fmt.Sprintf("batch=%d", count), func(b *testing.B) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			q, client := newBenchQueue(b, WithBatchCount(count))
+			deliveries := q.Consume(ctx, "bench")
+			data, err := JSONCodec[benchEvent]{}.Marshal(benchEvent{Message: "x"})
+			if err != nil {
+				b.Fatal(err)
+			}
+			_, err = client.Pipelined(ctx, func(p redis.Pipeliner) error {
+				for i := 0; i < b.N; i++ {
+					p.XAdd(ctx, &redis.XAddArgs{Stream: "bench", Values: map[string]interface{}{"data": data}})
+				}
+				return nil
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				d := <-deliveries
+				if err := d.Ack(ctx); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
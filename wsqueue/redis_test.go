@@ -0,0 +1,149 @@
+package wsqueue
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"testing"
+	"time"
+)
+
+func newTestRedisQueue(t *testing.T, m *miniredis.Miniredis, consumer string, opts ...Option) *RedisStreamQueue[string] {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	q, err := newRedisQueue[string](client, append([]Option{
+		WithGroup("g"),
+		WithConsumer(consumer),
+		WithChannelSize(10),
+		WithBlockTimeout(10 * time.Millisecond),
+	}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = q.Close(context.Background()) })
+	return q
+}
+
+// nackUntilDeadLettered nacks every delivery of the single event queued on
+// ch and returns how often it was delivered.
+func nackUntilDeadLettered(t *testing.T, ch <-chan Delivery[string]) int {
+	t.Helper()
+	deliveries := 0
+	for {
+		select {
+		case d := <-ch:
+			deliveries++
+			if err := d.Nack(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(200 * time.Millisecond):
+			return deliveries
+		}
+	}
+}
+
+func TestRedisQueueNackDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	q := newTestRedisQueue(t, m, "c1", WithReclaim(time.Hour, 3))
+	ch := q.Consume(ctx, "topic")
+
+	if err := q.ProduceEvent(ctx, "topic", "poison"); err != nil {
+		t.Fatal(err)
+	}
+	if n := nackUntilDeadLettered(t, ch); n != 3 {
+		t.Fatalf("delivered %d times, want 3", n)
+	}
+	dead, err := q.ListDeadLetters(ctx, "topic", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 {
+		t.Fatalf("got %d dead letters, want 1", len(dead))
+	}
+	if dead[0].Deliveries != 3 || dead[0].Consumer != "c1" || dead[0].Data != `"poison"` {
+		t.Fatalf("unexpected dead letter %+v", dead[0])
+	}
+	pending, err := q.client.XPending(ctx, "topic", "g").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending.Count != 0 {
+		t.Fatalf("%d entries still pending", pending.Count)
+	}
+}
+
+func TestRedisQueueReclaimsCrashedConsumer(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	crashed := newTestRedisQueue(t, m, "c1", WithReclaim(50*time.Millisecond, 5))
+	ch := crashed.Consume(ctx, "topic")
+	if err := crashed.ProduceEvent(ctx, "topic", "orphan"); err != nil {
+		t.Fatal(err)
+	}
+	// received but never settled
+	receive(t, ch)
+	if err := crashed.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	live := newTestRedisQueue(t, m, "c2", WithReclaim(50*time.Millisecond, 5))
+	d := receive(t, live.Consume(ctx, "topic"))
+	if d.Event != "orphan" {
+		t.Fatalf("got %q, want orphan", d.Event)
+	}
+	if err := d.Ack(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRedisQueueSlowReceiverKeepsEntry(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	slow := newTestRedisQueue(t, m, "c1", WithReclaim(50*time.Millisecond, 5))
+	fromSlow := slow.Consume(ctx, "topic")
+	if err := slow.ProduceEvent(ctx, "topic", "held"); err != nil {
+		t.Fatal(err)
+	}
+	d := receive(t, fromSlow)
+
+	other := newTestRedisQueue(t, m, "c2", WithReclaim(50*time.Millisecond, 5))
+	fromOther := other.Consume(ctx, "topic")
+	// several reclaim intervals pass while the slow receiver works
+	select {
+	case d := <-fromOther:
+		t.Fatalf("entry %s reclaimed from a live receiver", d.ID)
+	case <-time.After(300 * time.Millisecond):
+	}
+	if err := d.Ack(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expectNone(t, fromOther)
+}
+
+func TestRedisQueueCloseWaitsForBlockedRead(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	defer client.Close()
+	q, err := NewRedisQueueFromClient[string](client, WithGroup("g"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := q.Consume(ctx, "topic")
+	// let the reader block on an empty stream
+	time.Sleep(50 * time.Millisecond)
+	closeCtx, cancel := context.WithTimeout(ctx, 3*defaultBlockTimeout)
+	defer cancel()
+	start := time.Now()
+	if err := q.Close(closeCtx); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took > defaultBlockTimeout+500*time.Millisecond {
+		t.Fatalf("Close took %v", took)
+	}
+	if _, ok := <-ch; ok {
+		t.Fatal("Consume channel still open after Close")
+	}
+}
@@ -0,0 +1,84 @@
+package wsqueue
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReplayQueue is implemented by queues that keep published events around,
+// so a consumer that was away can read what it missed.
+type ReplayQueue[T any] interface {
+	// Replay returns up to count entries published to topic after the entry
+	// with ID after, oldest first.
+	Replay(ctx context.Context, topic, after string, count int64) ([]Entry[T], error)
+}
+
+var _ ReplayQueue[any] = &RedisStreamQueue[any]{}
+
+// Entry is an event read back from a topic together with its ID.
+type Entry[T any] struct {
+	ID    string
+	Event T
+}
+
+// Replay implements ReplayQueue. Entries that fail to decode are skipped;
+// entries already trimmed by the topic's retention are gone.
+func (q *RedisStreamQueue[T]) Replay(ctx context.Context, topic, after string, count int64) ([]Entry[T], error) {
+	start := "-"
+	if after != "" {
+		// exclusive range start
+		start = "(" + after
+	}
+	msgs, err := q.client.XRangeN(ctx, topic, start, "+", count).Result()
+	if err != nil {
+		return nil, fmt.Errorf("Replay: XRange: %w", err)
+	}
+	entries := make([]Entry[T], 0, len(msgs))
+	for _, msg := range msgs {
+		evt, err := q.decode(msg)
+		if err != nil {
+			slog.Error("Replay: skipping entry", "err", err, "topic", topic, "id", msg.ID)
+			continue
+		}
+		entries = append(entries, Entry[T]{ID: msg.ID, Event: evt})
+	}
+	return entries, nil
+}
+
+// CompareIDs orders two entry IDs of the same topic, returning -1, 0 or +1.
+// Both Redis stream IDs ("ms-seq") and plain sequence numbers are
+// understood.
+func CompareIDs(a, b string) int {
+	am, as := splitID(a)
+	bm, bs := splitID(b)
+	if am != bm {
+		return cmp.Compare(am, bm)
+	}
+	return cmp.Compare(as, bs)
+}
+
+// IDTime returns when the entry with Redis stream ID id was added, or false
+// if id carries no timestamp.
+func IDTime(id string) (time.Time, bool) {
+	ms, _, ok := strings.Cut(id, "-")
+	if !ok {
+		return time.Time{}, false
+	}
+	v, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(v), true
+}
+
+func splitID(id string) (uint64, uint64) {
+	ms, seq, _ := strings.Cut(id, "-")
+	m, _ := strconv.ParseUint(ms, 10, 64)
+	s, _ := strconv.ParseUint(seq, 10, 64)
+	return m, s
+}
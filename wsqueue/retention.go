@@ -0,0 +1,108 @@
+package wsqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Retention bounds how much of a stream Redis keeps. Zero values disable
+// the corresponding limit.
+type Retention struct {
+	// MaxLen caps the stream at roughly this many entries (XADD MAXLEN ~).
+	MaxLen int64
+	// MaxAge drops entries older than this (XTRIM MINID ~).
+	MaxAge time.Duration
+	// IdleTTL deletes the whole stream, consumer groups included, once
+	// nothing has been produced to it for this long.
+	IdleTTL time.Duration
+}
+
+// retention returns the policy for topic: the longest matching prefix set
+// with WithTopicRetention, otherwise the queue-wide one.
+func (o redisOptions) retentionFor(topic string) Retention {
+	r := o.retention
+	best := -1
+	for prefix, pr := range o.topicRetention {
+		if strings.HasPrefix(topic, prefix) && len(prefix) > best {
+			r = pr
+			best = len(prefix)
+		}
+	}
+	return r
+}
+
+// xadd appends values to topic and applies the retention policy r in the
+// same round trip.
+func (q *RedisStreamQueue[T]) xadd(ctx context.Context, r Retention, topic string, values map[string]interface{}) error {
+	if r.MaxAge <= 0 && r.IdleTTL <= 0 {
+		if err := q.client.XAdd(ctx, xaddArgs(r, topic, values)).Err(); err != nil {
+			return fmt.Errorf("XAdd: %w", err)
+		}
+		return nil
+	}
+	_, err := q.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.XAdd(ctx, xaddArgs(r, topic, values))
+		trim(ctx, p, r, topic)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("XAdd: %w", err)
+	}
+	return nil
+}
+
+// xaddBatch appends every entry in batch to topic in a single pipeline,
+// trimming the stream once at the end.
+func (q *RedisStreamQueue[T]) xaddBatch(ctx context.Context, topic string, batch []map[string]interface{}) error {
+	r := q.opts.retentionFor(topic)
+	_, err := q.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, values := range batch {
+			p.XAdd(ctx, xaddArgs(r, topic, values))
+		}
+		trim(ctx, p, r, topic)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("XAdd: %w", err)
+	}
+	return nil
+}
+
+func xaddArgs(r Retention, topic string, values map[string]interface{}) *redis.XAddArgs {
+	args := &redis.XAddArgs{
+		Stream: topic,
+		Values: values,
+	}
+	if r.MaxLen > 0 {
+		args.MaxLen = r.MaxLen
+		args.Approx = true
+	}
+	return args
+}
+
+// trim queues the age and idle limits of r, which XADD cannot apply
+// alongside MAXLEN.
+func trim(ctx context.Context, p redis.Pipeliner, r Retention, topic string) {
+	if r.MaxAge > 0 {
+		minID := strconv.FormatInt(time.Now().Add(-r.MaxAge).UnixMilli(), 10) + "-0"
+		p.XTrimMinIDApprox(ctx, topic, minID, 0)
+	}
+	if r.IdleTTL > 0 {
+		p.Expire(ctx, topic, r.IdleTTL)
+	}
+}
+
+// DeleteTopic removes the topic stream together with its consumer groups.
+// Its dead letters are kept for inspection. After Close it needs a client
+// that outlives the queue, see NewRedisQueueFromClient.
+func (q *RedisStreamQueue[T]) DeleteTopic(ctx context.Context, topic string) error {
+	if err := q.client.Del(ctx, topic).Err(); err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("DeleteTopic: %w", err)
+	}
+	return nil
+}
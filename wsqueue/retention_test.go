@@ -0,0 +1,80 @@
+package wsqueue
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRetentionFor(t *testing.T) {
+	o := defaultRedisOptions()
+	WithRetention(Retention{MaxLen: 1})(&o)
+	WithTopicRetention("ses_", Retention{MaxLen: 2})(&o)
+	WithTopicRetention("ses_audit_", Retention{MaxLen: 3})(&o)
+	for topic, want := range map[string]int64{
+		"other":        1,
+		"ses_1":        2,
+		"ses_audit_1":  3,
+		"audit_ses_1":  1,
+		"ses_audit":    2,
+		"ses_audit_1_": 3,
+	} {
+		if got := o.retentionFor(topic).MaxLen; got != want {
+			t.Errorf("retentionFor(%q).MaxLen = %d, want %d", topic, got, want)
+		}
+	}
+}
+
+func TestRetentionMaxLen(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	q := newTestRedisQueue(t, m, "c1", WithTopicRetention("ses_", Retention{MaxLen: 5}))
+	for i := 0; i < 20; i++ {
+		if err := q.ProduceEvent(ctx, "ses_1", "e"); err != nil {
+			t.Fatal(err)
+		}
+		if err := q.ProduceEvent(ctx, "other", "e"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// MAXLEN ~ may keep a few more entries on a real server
+	if n := q.client.XLen(ctx, "ses_1").Val(); n < 5 || n >= 20 {
+		t.Fatalf("ses_1 has %d entries, want about 5", n)
+	}
+	if n := q.client.XLen(ctx, "other").Val(); n != 20 {
+		t.Fatalf("other has %d entries, want all 20", n)
+	}
+}
+
+func TestRetentionMaxAge(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	q := newTestRedisQueue(t, m, "c1", WithRetention(Retention{MaxAge: time.Hour, IdleTTL: 6 * time.Hour}))
+	old := time.Now().Add(-2 * time.Hour).UnixMilli()
+	for i := int64(0); i < 3; i++ {
+		err := q.client.XAdd(ctx, &redis.XAddArgs{
+			Stream: "topic",
+			ID:     strconv.FormatInt(old+i, 10) + "-0",
+			Values: map[string]interface{}{"data": `"old"`},
+		}).Err()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.ProduceEvent(ctx, "topic", "new"); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := q.client.XRange(ctx, "topic", "-", "+").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Values["data"] != `"new"` {
+		t.Fatalf("got %v, want only the new entry", msgs)
+	}
+	if ttl := m.TTL("topic"); ttl != 6*time.Hour {
+		t.Fatalf("ttl %v, want 6h", ttl)
+	}
+}
@@ -0,0 +1,204 @@
+package wssession
+
+import (
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Seann-Moser/multiws/wsmodels"
+	"github.com/golang-jwt/jwt/v5"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrUnauthorized is returned by authenticators when a request carries no
+// credentials or invalid ones.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Authenticator verifies who is opening a WebSocket. It runs before the
+// upgrade, so it only sees the handshake request.
+type Authenticator interface {
+	// Authenticate returns the verified user behind r. Errors reject the
+	// upgrade with 401.
+	Authenticate(r *http.Request) (*wsmodels.User, error)
+}
+
+// AuthenticatorFunc adapts a function to Authenticator.
+type AuthenticatorFunc func(r *http.Request) (*wsmodels.User, error)
+
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (*wsmodels.User, error) {
+	return f(r)
+}
+
+// UserClaims are the JWT claims mapped onto a wsmodels.User: sub becomes
+// Id, name Name and picture ProfileUrl.
+type UserClaims struct {
+	jwt.RegisteredClaims
+	Name    string `json:"name,omitempty"`
+	Picture string `json:"picture,omitempty"`
+}
+
+// JWTAuthenticator accepts JWT bearer tokens from the Authorization header
+// or, since browsers cannot set headers on a WebSocket, from the
+// access_token query parameter.
+type JWTAuthenticator struct {
+	parser  *jwt.Parser
+	keyFunc jwt.Keyfunc
+}
+
+// NewHMACAuthenticator verifies HS256/384/512 tokens signed with secret.
+// opts add checks such as jwt.WithIssuer or jwt.WithAudience.
+func NewHMACAuthenticator(secret []byte, opts ...jwt.ParserOption) *JWTAuthenticator {
+	return newJWTAuthenticator(func(*jwt.Token) (interface{}, error) {
+		return secret, nil
+	}, []string{"HS256", "HS384", "HS512"}, opts)
+}
+
+// NewRSAAuthenticator verifies RS256/384/512 tokens signed by the private
+// half of key.
+func NewRSAAuthenticator(key *rsa.PublicKey, opts ...jwt.ParserOption) *JWTAuthenticator {
+	return newJWTAuthenticator(func(*jwt.Token) (interface{}, error) {
+		return key, nil
+	}, []string{"RS256", "RS384", "RS512"}, opts)
+}
+
+func newJWTAuthenticator(keyFunc jwt.Keyfunc, methods []string, opts []jwt.ParserOption) *JWTAuthenticator {
+	// pinning the methods stops tokens from choosing their own algorithm
+	opts = append([]jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}, opts...)
+	return &JWTAuthenticator{
+		parser:  jwt.NewParser(opts...),
+		keyFunc: keyFunc,
+	}
+}
+
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*wsmodels.User, error) {
+	raw := bearerToken(r)
+	if raw == "" {
+		return nil, fmt.Errorf("Authenticate: missing bearer token: %w", ErrUnauthorized)
+	}
+	var claims UserClaims
+	if _, err := a.parser.ParseWithClaims(raw, &claims, a.keyFunc); err != nil {
+		return nil, fmt.Errorf("Authenticate: %w: %w", ErrUnauthorized, err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("Authenticate: token has no subject: %w", ErrUnauthorized)
+	}
+	return &wsmodels.User{
+		Id:         claims.Subject,
+		Name:       claims.Name,
+		ProfileUrl: claims.Picture,
+	}, nil
+}
+
+func bearerToken(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+// Ticket is the payload of a signed query ticket.
+type Ticket struct {
+	UserID     string `json:"uid"`
+	Name       string `json:"name,omitempty"`
+	ProfileUrl string `json:"pic,omitempty"`
+	Expires    int64  `json:"exp"`
+}
+
+// TicketAuthenticator accepts short-lived tickets in the ticket query
+// parameter. A ticket is issued over an already authenticated HTTP request
+// and handed to the browser for the WebSocket URL, so long-lived
+// credentials never end up in URLs or logs.
+type TicketAuthenticator struct {
+	secret []byte
+}
+
+// NewTicketAuthenticator signs and verifies tickets with secret.
+func NewTicketAuthenticator(secret []byte) *TicketAuthenticator {
+	return &TicketAuthenticator{secret: secret}
+}
+
+// Issue returns a ticket for user that expires after ttl.
+func (a *TicketAuthenticator) Issue(user *wsmodels.User, ttl time.Duration) (string, error) {
+	payload, err := json.Marshal(Ticket{
+		UserID:     user.Id,
+		Name:       user.Name,
+		ProfileUrl: user.ProfileUrl,
+		Expires:    time.Now().Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Issue: %w", err)
+	}
+	p := base64.RawURLEncoding.EncodeToString(payload)
+	return p + "." + base64.RawURLEncoding.EncodeToString(a.sign(p)), nil
+}
+
+func (a *TicketAuthenticator) Authenticate(r *http.Request) (*wsmodels.User, error) {
+	p, sig, ok := strings.Cut(r.URL.Query().Get("ticket"), ".")
+	if !ok {
+		return nil, fmt.Errorf("Authenticate: missing ticket: %w", ErrUnauthorized)
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, a.sign(p)) {
+		return nil, fmt.Errorf("Authenticate: invalid ticket signature: %w", ErrUnauthorized)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(p)
+	if err != nil {
+		return nil, fmt.Errorf("Authenticate: %w: %w", ErrUnauthorized, err)
+	}
+	var t Ticket
+	if err := json.Unmarshal(payload, &t); err != nil {
+		return nil, fmt.Errorf("Authenticate: %w: %w", ErrUnauthorized, err)
+	}
+	if t.UserID == "" || time.Now().Unix() >= t.Expires {
+		return nil, fmt.Errorf("Authenticate: ticket expired: %w", ErrUnauthorized)
+	}
+	return &wsmodels.User{Id: t.UserID, Name: t.Name, ProfileUrl: t.ProfileUrl}, nil
+}
+
+func (a *TicketAuthenticator) sign(payload string) []byte {
+	m := hmac.New(sha256.New, a.secret)
+	m.Write([]byte(payload))
+	return m.Sum(nil)
+}
+
+// Authenticate runs the configured Authenticator on the handshake request
+// and returns the verified user, which Init then joins to the session.
+// Without an Authenticator it returns nil and Init trusts the user it is
+// given.
+func (s *BaseSession) Authenticate(r *http.Request) (*wsmodels.User, error) {
+	if s.authenticator == nil {
+		return nil, nil
+	}
+	user, err := s.authenticator.Authenticate(r)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.Id == "" {
+		return nil, fmt.Errorf("Authenticate: no user: %w", ErrUnauthorized)
+	}
+	s.verified = user
+	return user, nil
+}
+
+// sessionUser returns the user Init joins: the verified user when an
+// Authenticator is configured, which user may only repeat.
+func (s *BaseSession) sessionUser(user *wsmodels.User) (*wsmodels.User, error) {
+	if s.authenticator == nil {
+		return user, nil
+	}
+	if s.verified == nil {
+		return nil, fmt.Errorf("not authenticated: %w", ErrUnauthorized)
+	}
+	if user == nil {
+		return s.verified, nil
+	}
+	if user.Id != s.verified.Id {
+		return nil, fmt.Errorf("user %q is not the authenticated user: %w", user.Id, ErrUnauthorized)
+	}
+	return user, nil
+}
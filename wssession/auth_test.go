@@ -0,0 +1,172 @@
+package wssession
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"github.com/Seann-Moser/multiws/wsmodels"
+	"github.com/golang-jwt/jwt/v5"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func ticketRequest(ticket string) *http.Request {
+	return httptest.NewRequest("GET", "/ws?ticket="+url.QueryEscape(ticket), nil)
+}
+
+func TestTicketAuthenticator(t *testing.T) {
+	a := NewTicketAuthenticator([]byte("secret"))
+	issue := func(user *wsmodels.User, ttl time.Duration) string {
+		ticket, err := a.Issue(user, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ticket
+	}
+	valid := issue(&wsmodels.User{Id: "u1", Name: "Ann", ProfileUrl: "pic"}, time.Minute)
+	payload, sig, _ := strings.Cut(valid, ".")
+	forged, _ := NewTicketAuthenticator([]byte("other")).Issue(&wsmodels.User{Id: "u1"}, time.Minute)
+
+	tests := []struct {
+		name   string
+		ticket string
+		want   string
+	}{
+		{"valid", valid, "u1"},
+		{"missing", "", ""},
+		{"no signature", payload, ""},
+		{"tampered signature", payload + "." + sig[1:] + "A", ""},
+		{"undecodable signature", payload + ".!!", ""},
+		{"tampered payload", base64.RawURLEncoding.EncodeToString([]byte(`{"uid":"admin","exp":9999999999}`)) + "." + sig, ""},
+		{"other secret", forged, ""},
+		{"expired", issue(&wsmodels.User{Id: "u1"}, -time.Second), ""},
+		{"no user", issue(&wsmodels.User{}, time.Minute), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := a.Authenticate(ticketRequest(tt.ticket))
+			if tt.want == "" {
+				if !errors.Is(err, ErrUnauthorized) {
+					t.Fatalf("got %v, %v, want ErrUnauthorized", user, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.Id != tt.want {
+				t.Fatalf("got user %q, want %q", user.Id, tt.want)
+			}
+		})
+	}
+
+	user, _ := a.Authenticate(ticketRequest(valid))
+	if user.Name != "Ann" || user.ProfileUrl != "pic" {
+		t.Fatalf("ticket user %+v lost its profile", user)
+	}
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	secret := []byte("secret")
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(method jwt.SigningMethod, key interface{}, claims jwt.Claims) string {
+		raw, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	claims := func(sub string, exp time.Duration) UserClaims {
+		return UserClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject:   sub,
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(exp)),
+			},
+			Name:    "Ann",
+			Picture: "pic",
+		}
+	}
+	valid := claims("u1", time.Minute)
+	hmacAuth := NewHMACAuthenticator(secret)
+	rsaAuth := NewRSAAuthenticator(&key.PublicKey)
+
+	tests := []struct {
+		name  string
+		auth  *JWTAuthenticator
+		token string
+		query bool
+		want  string
+	}{
+		{"valid HS256", hmacAuth, sign(jwt.SigningMethodHS256, secret, valid), false, "u1"},
+		{"valid HS512", hmacAuth, sign(jwt.SigningMethodHS512, secret, valid), false, "u1"},
+		{"access_token parameter", hmacAuth, sign(jwt.SigningMethodHS256, secret, valid), true, "u1"},
+		{"valid RS256", rsaAuth, sign(jwt.SigningMethodRS256, key, valid), false, "u1"},
+		{"missing", hmacAuth, "", false, ""},
+		{"wrong secret", hmacAuth, sign(jwt.SigningMethodHS256, []byte("other"), valid), false, ""},
+		{"tampered", hmacAuth, sign(jwt.SigningMethodHS256, secret, valid) + "x", false, ""},
+		{"expired", hmacAuth, sign(jwt.SigningMethodHS256, secret, claims("u1", -time.Minute)), false, ""},
+		{"no expiry", hmacAuth, sign(jwt.SigningMethodHS256, secret, UserClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: "u1"}}), false, ""},
+		{"no subject", hmacAuth, sign(jwt.SigningMethodHS256, secret, claims("", time.Minute)), false, ""},
+		{"alg none", hmacAuth, sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, valid), false, ""},
+		{"RS256 to HMAC", hmacAuth, sign(jwt.SigningMethodRS256, key, valid), false, ""},
+		// signed with the public key as HMAC secret
+		{"HS256 to RSA", rsaAuth, sign(jwt.SigningMethodHS256, key.PublicKey.N.Bytes(), valid), false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/ws", nil)
+			switch {
+			case tt.query:
+				r = httptest.NewRequest("GET", "/ws?access_token="+url.QueryEscape(tt.token), nil)
+			case tt.token != "":
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			user, err := tt.auth.Authenticate(r)
+			if tt.want == "" {
+				if !errors.Is(err, ErrUnauthorized) {
+					t.Fatalf("got %v, %v, want ErrUnauthorized", user, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.Id != tt.want || user.Name != "Ann" || user.ProfileUrl != "pic" {
+				t.Fatalf("got user %+v, want %q", user, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionUser(t *testing.T) {
+	a := NewTicketAuthenticator([]byte("secret"))
+	ticket, err := a.Issue(&wsmodels.User{Id: "u1", Name: "Ann"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &BaseSession{authenticator: a}
+	if _, err := s.sessionUser(&wsmodels.User{Id: "u1"}); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("joined without authenticating: %v", err)
+	}
+	if _, err := s.Authenticate(ticketRequest(ticket)); err != nil {
+		t.Fatal(err)
+	}
+	if u, err := s.sessionUser(nil); err != nil || u.Name != "Ann" {
+		t.Fatalf("got %v, %v, want the verified user", u, err)
+	}
+	if _, err := s.sessionUser(&wsmodels.User{Id: "u2"}); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("joined as another user: %v", err)
+	}
+	// without an authenticator the given user is trusted
+	if u, err := (&BaseSession{}).sessionUser(&wsmodels.User{Id: "u2"}); err != nil || u.Id != "u2" {
+		t.Fatalf("got %v, %v, want u2", u, err)
+	}
+}
@@ -1,13 +1,18 @@
 package wssession
 
 import (
+	"compress/flate"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/Seann-Moser/multiws/wsmodels"
 	"github.com/Seann-Moser/multiws/wsqueue"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/redis/go-redis/v9"
 	"log/slog"
+	"net"
 	"net/http"
 	"sync"
 	"time"
@@ -17,17 +22,85 @@ var _ Session = &BaseSession{}
 
 type BaseSession struct {
 	sessionCache   redis.Cmdable
+	store          sessionStore
 	queue          wsqueue.Queue[wsmodels.Event]
 	currentSession *wsmodels.Session
+	// stateMu guards currentSession, including Self, and lastEventSent,
+	// which the connection goroutines share; see self and updateSelf
+	stateMu sync.Mutex
 
 	produceChan chan<- wsmodels.Event
-	consumeChan <-chan wsmodels.Event
-
-	wsOutbound chan wsmodels.Event
+	consumeChan <-chan wsqueue.Delivery[wsmodels.Event]
+
+	// queue deliveries waiting to be written to the socket; each is acked
+	// only after the write succeeds
+	wsOutbound chan wsqueue.Delivery[wsmodels.Event]
+
+	// wireCodec encodes events on the WebSocket unless the client
+	// negotiates one of codecs as its subprotocol
+	wireCodec wsmodels.Codec
+	codecs    []wsmodels.Codec
+
+	upgrader websocket.Upgrader
+	// compressionLevel applies when upgrader.EnableCompression is set
+	compressionLevel int
+
+	// pingInterval, pongWait and writeWait drive the heartbeat and write
+	// deadlines of each connection
+	pingInterval time.Duration
+	pongWait     time.Duration
+	writeWait    time.Duration
+
+	readLimit    int64
+	userLimit    RateLimit
+	sessionLimit RateLimit
+	violation    ViolationPolicy
+
+	authenticator Authenticator
+	// verified is the user Authenticate vouched for
+	verified *wsmodels.User
+	policy   Policy
+	router   *Router
+
+	// pending holds the Requests waiting for a reply, by event ID
+	pendingMu      sync.Mutex
+	pending        map[string]pendingRequest
+	requestTimeout time.Duration
+
+	// hostLease is how long the host holds the session without renewing;
+	// electNow triggers an election before the next renewal
+	hostLease time.Duration
+	electNow  chan struct{}
+
+	// maxHistory caps the history of sessions this one creates
+	maxHistory int
+
+	// replayMax and replayAge bound the events replayed to a reconnecting
+	// client
+	replayMax int64
+	replayAge time.Duration
+
+	// direct carries server generated events, such as errors, that go to
+	// this client only and never through the queue
+	direct chan wsmodels.Event
 
 	lastEventSent time.Time
 }
 
+// queueCloseTimeout bounds how long Disconnect waits for queued events to
+// be flushed. Closing also waits for the queue's blocked reads, so it must
+// outlast the queue's block timeout (see wsqueue.WithBlockTimeout).
+const queueCloseTimeout = 5 * time.Second
+
+const defaultMaxHistory = 20
+
+const (
+	defaultPongWait     = 60 * time.Second
+	defaultPingInterval = defaultPongWait * 9 / 10
+	defaultWriteWait    = 10 * time.Second
+)
+
+// upgrader holds the defaults every BaseSession starts from.
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
@@ -38,98 +111,115 @@ var upgrader = websocket.Upgrader{
 
 func NewBaseSession(
 	r redis.Cmdable,
-	queue wsqueue.Queue[wsmodels.Event]) Session {
-	return &BaseSession{
+	queue wsqueue.Queue[wsmodels.Event], opts ...Option) Session {
+	s := &BaseSession{
 		sessionCache: r,
+		store:        sessionStore{c: r},
 		queue:        queue,
-		wsOutbound:   make(chan wsmodels.Event, 100),
+		wsOutbound:   make(chan wsqueue.Delivery[wsmodels.Event], 100),
+		direct:       make(chan wsmodels.Event, 16),
+		pending:      map[string]pendingRequest{},
+		wireCodec:    wsmodels.JSON,
+		codecs:       []wsmodels.Codec{wsmodels.JSON, wsmodels.MsgPack, wsmodels.Protobuf},
+		upgrader:     upgrader,
+		pingInterval: defaultPingInterval,
+		pongWait:     defaultPongWait,
+		writeWait:    defaultWriteWait,
+
+		compressionLevel: flate.BestSpeed,
+		requestTimeout:   defaultRequestTimeout,
+		hostLease:        defaultHostLease,
+		electNow:         make(chan struct{}, 1),
+		maxHistory:       defaultMaxHistory,
+		replayMax:        defaultReplayMax,
+		replayAge:        defaultReplayAge,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 func (s *BaseSession) ID() string {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
 	if s.currentSession == nil {
 		return ""
 	}
 	return s.currentSession.ID
 }
 
-func (s *BaseSession) Status() string {
-	return ""
+// self returns a copy of this session's user, or false before Init and
+// after Disconnect.
+func (s *BaseSession) self() (wsmodels.User, bool) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if s.currentSession == nil {
+		return wsmodels.User{}, false
+	}
+	return s.currentSession.Self, true
 }
 
-func (s *BaseSession) getSession(ctx context.Context, key string) (*wsmodels.Session, error) {
-	results := s.sessionCache.Get(ctx, key)
-	if results.Err() != nil {
-		return nil, results.Err()
-	}
-	var session wsmodels.Session
-	err := results.Scan(&session)
-	if err != nil {
-		return nil, err
+// updateSelf applies fn to this session's user and returns a copy of the
+// result, or false before Init and after Disconnect.
+func (s *BaseSession) updateSelf(fn func(u *wsmodels.User)) (wsmodels.User, bool) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if s.currentSession == nil {
+		return wsmodels.User{}, false
 	}
-	return &session, nil
+	fn(&s.currentSession.Self)
+	return s.currentSession.Self, true
 }
 
-func (s *BaseSession) setSession(ctx context.Context, key string, session *wsmodels.Session) error {
-	results := s.sessionCache.Set(ctx, key, session, 6*time.Hour)
-	if results.Err() != nil {
-		return results.Err()
-	}
-	return nil
+// forget drops the local copy of the session once this user is gone.
+func (s *BaseSession) forget() {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	s.currentSession = nil
+}
+
+func (s *BaseSession) Status() string {
+	return ""
 }
 
 func (s *BaseSession) Init(ctx context.Context, sessionID string, user *wsmodels.User) error {
-	if s.currentSession != nil {
+	if _, ok := s.self(); ok {
 		return fmt.Errorf("session already initialized")
 	}
-	s.lastEventSent = time.Now()
-	key := sessionID + "_session_info"
-	///2025/06/01 13:32:36 INFO session found in cache session=""
-	ses, err := s.getSession(ctx, key)
-	if err == nil {
-
-		s.currentSession = ses
-		if s.ID() == "" {
-			slog.Error("session id is missing", "session", ses)
-			return fmt.Errorf("session not found")
-		}
-		slog.Info("session found in cache", "session", s.ID())
-		if user != nil {
-			user.Host = len(s.currentSession.Users) == 0
-			user.Joined = time.Now().Unix()
-			user.Status = wsmodels.StatusConnected
-			user.LastSeen = time.Now().Unix()
-			if user.Meta == nil {
-				user.Meta = map[string]interface{}{}
-			}
-			s.currentSession.Self = *user
-		}
-		s.produceChan = s.queue.Produce(ctx, "ses_"+s.ID())
-		s.consumeChan = s.queue.Subscribe(ctx, "ses_"+s.ID())
-
-		e := wsmodels.Event{
-			Type: wsmodels.EventTypeUserJoined,
-		}
-		err = e.Set(s.currentSession.Self)
-		if err != nil {
-			return err
-		}
-		slog.Info("session joined", "user", e)
-		s.SendEvent(ctx, e)
-		return nil
+	// refuse before anything is stored or announced
+	user, err := s.sessionUser(user)
+	if err != nil {
+		return fmt.Errorf("Init: %w", err)
 	}
-	slog.Info("session not found in cache")
-
-	session := wsmodels.Session{
+	// the roster is keyed by ID, so anonymous users would overwrite each other
+	if user != nil && user.Id == "" {
+		return fmt.Errorf("Init: user has no ID")
+	}
+	s.lastEventSent = time.Now()
+	session := &wsmodels.Session{
 		Users:        []*wsmodels.User{},
 		ID:           sessionID,
 		History:      make([]*wsmodels.Event, 0),
-		MaxHistory:   20,
+		MaxHistory:   s.maxHistory,
 		IdleDuration: time.Minute,
 		Meta:         map[string]interface{}{},
 	}
+	created, err := s.store.create(ctx, session)
+	if err != nil {
+		slog.Error("failed to create session", "err", err)
+		return err
+	}
+	if !created {
+		session, err = s.store.load(ctx, sessionID)
+		if err != nil {
+			return fmt.Errorf("failed to load session: %w", err)
+		}
+		slog.Info("session found in cache", "session", sessionID)
+	}
 	if user != nil {
-		user.Host = true
+		// elect decides once the user is stored
+		user.Host = false
 		user.Joined = time.Now().Unix()
 		user.Status = wsmodels.StatusConnected
 		user.LastSeen = time.Now().Unix()
@@ -137,67 +227,258 @@ func (s *BaseSession) Init(ctx context.Context, sessionID string, user *wsmodels
 			user.Meta = map[string]interface{}{}
 		}
 		session.Self = *user
+		session.Version, err = s.store.setField(ctx, sessionID, kindUsers, user.Id, user)
+		if err != nil {
+			return fmt.Errorf("failed to store session user: %w", err)
+		}
+	}
+	s.stateMu.Lock()
+	s.currentSession = session
+	s.stateMu.Unlock()
+	s.produceChan = s.queue.Produce(ctx, "ses_"+s.ID())
+	s.consumeChan = s.queue.Consume(ctx, "ses_"+s.ID())
+	if user != nil {
+		s.elect(ctx)
+	}
+	if created {
+		return nil
 	}
-	slog.Info("session", "session", session)
 
-	err = s.setSession(ctx, key, &session)
+	e := wsmodels.Event{
+		Type: wsmodels.EventTypeUserJoined,
+	}
+	self, _ := s.self()
+	err = e.Set(self)
 	if err != nil {
-		slog.Error("failed to set session info", "err", err)
 		return err
 	}
-	s.currentSession = &session
-	s.produceChan = s.queue.Produce(ctx, "ses_"+s.ID())
-	s.consumeChan = s.queue.Subscribe(ctx, "ses_"+s.ID())
+	slog.Info("session joined", "user", e)
+	// lifecycle events are the server's own and bypass the policy
+	s.publish(ctx, e)
+	return nil
+}
 
+// Reload replaces the local copy of the session with the state stored in
+// Redis, keeping this session's own user.
+func (s *BaseSession) Reload(ctx context.Context) error {
+	if _, ok := s.self(); !ok {
+		return fmt.Errorf("session not initialized")
+	}
+	session, err := s.store.load(ctx, s.ID())
+	if err != nil {
+		return fmt.Errorf("Reload: %w", err)
+	}
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if s.currentSession == nil {
+		return fmt.Errorf("session not initialized")
+	}
+	session.Self = s.currentSession.Self
+	s.currentSession = session
 	return nil
 }
 
-func (s *BaseSession) User() *wsmodels.User {
+// SetMeta stores value under key in the session's Meta for every member.
+// A nil value removes the key.
+func (s *BaseSession) SetMeta(ctx context.Context, key string, value interface{}) error {
+	return s.UpdateMeta(ctx, key, func(interface{}) (interface{}, error) {
+		return value, nil
+	})
+}
+
+// UpdateMeta replaces the value under key in the session's Meta with the
+// result of fn, which is called with the current value. If another member
+// changes the session in between, fn is called again with the new value.
+func (s *BaseSession) UpdateMeta(ctx context.Context, key string, fn func(current interface{}) (interface{}, error)) error {
+	if _, ok := s.self(); !ok {
+		return fmt.Errorf("session not initialized")
+	}
+	var updated interface{}
+	version, err := s.store.updateField(ctx, s.ID(), kindMeta, key, func(raw []byte) (interface{}, error) {
+		var current interface{}
+		if raw != nil {
+			if err := json.Unmarshal(raw, &current); err != nil {
+				return nil, fmt.Errorf("failed to decode meta %q: %w", key, err)
+			}
+		}
+		v, err := fn(current)
+		updated = v
+		return v, err
+	})
+	if err != nil {
+		return fmt.Errorf("UpdateMeta: %w", err)
+	}
+	s.stateMu.Lock()
+	if s.currentSession != nil {
+		s.currentSession.Version = max(s.currentSession.Version, version)
+	}
+	s.stateMu.Unlock()
+	change := wsmodels.MetaChange{Key: key, Value: updated}
+	s.applyMeta(change)
+
+	// the other members apply it when the event reaches them
+	e := wsmodels.Event{Type: wsmodels.EventTypeMetaChanged}
+	if err := e.Set(change); err != nil {
+		return fmt.Errorf("UpdateMeta: %w", err)
+	}
+	s.publish(ctx, e)
+	return nil
+}
+
+// applyMeta updates the local copy of the session's Meta.
+func (s *BaseSession) applyMeta(change wsmodels.MetaChange) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
 	if s.currentSession == nil {
+		return
+	}
+	if s.currentSession.Meta == nil {
+		s.currentSession.Meta = map[string]interface{}{}
+	}
+	if change.Value == nil {
+		delete(s.currentSession.Meta, change.Key)
+	} else {
+		s.currentSession.Meta[change.Key] = change.Value
+	}
+}
+
+// persist records in Redis what an event published by this session's user
+// changes about the session.
+func (s *BaseSession) persist(ctx context.Context, e wsmodels.Event) error {
+	var err error
+	switch e.Type {
+	case wsmodels.EventTypeUserDataChanged, wsmodels.EventTypeUserLeft, wsmodels.EventTypeHostChanged:
+		self, _ := s.self()
+		_, err = s.store.setField(ctx, s.ID(), kindUsers, self.Id, &self)
+	case wsmodels.EventTypeGeneral:
+		if shared(e) {
+			_, err = s.store.pushHistory(ctx, s.ID(), &e)
+		}
+	}
+	return err
+}
+
+// User returns a copy of this session's user.
+func (s *BaseSession) User() *wsmodels.User {
+	self, ok := s.self()
+	if !ok {
 		return nil
 	}
-	return &s.currentSession.Self
+	return &self
 }
 
 func (s *BaseSession) ListUsers() []*wsmodels.User {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
 	if s.currentSession == nil {
 		return nil
 	}
-	return s.currentSession.Users
+	return append([]*wsmodels.User(nil), s.currentSession.Users...)
 }
 
 func (s *BaseSession) Disconnect() {
-	if s.currentSession == nil {
+	if _, ok := s.updateSelf(func(u *wsmodels.User) { u.Status = wsmodels.StatusDisconnected }); !ok {
 		return
 	}
+	if s.ended(context.Background()) {
+		// announcing the leave would only recreate the deleted stream
+		s.closeQueue()
+		s.forget()
+		return
+	}
+	// lets the next host take over without waiting for the lease to expire
+	s.releaseHost(context.Background())
 	e := wsmodels.Event{
 		Type: wsmodels.EventTypeUserLeft,
 	}
-	err := e.Set(s.currentSession.Self)
+	self, _ := s.self()
+	err := e.Set(self)
 	if err != nil {
 		return
 	}
-	s.SendEvent(context.Background(), e)
+	s.publish(context.Background(), e)
 
-	s.queue.Close()
-	s.currentSession = nil
+	// flushes the UserLeft event before the queue shuts down
+	s.closeQueue()
+	s.forget()
 }
 
-func (s *BaseSession) GetHistory() []*wsmodels.Event {
-	if s.currentSession == nil {
-		return nil
+// ended reports whether another member ended the session, see End.
+func (s *BaseSession) ended(ctx context.Context) bool {
+	ok, err := s.store.exists(ctx, s.ID())
+	if err != nil {
+		slog.Error("failed to check session", "err", err)
+		return false
 	}
-	return s.currentSession.History
+	return !ok
+}
+
+func (s *BaseSession) closeQueue() {
+	ctx, cancel := context.WithTimeout(context.Background(), queueCloseTimeout)
+	defer cancel()
+	if err := s.queue.Close(ctx); err != nil {
+		slog.Error("failed to close queue", "err", err)
+	}
+}
+
+func (s *BaseSession) End(ctx context.Context) error {
+	if _, ok := s.self(); !ok {
+		return fmt.Errorf("session not initialized")
+	}
+	if err := s.authorize(ctx, wsmodels.Event{Type: ActionEndSession}); err != nil {
+		return fmt.Errorf("End: %w", err)
+	}
+	id := s.ID()
+	if err := s.store.delete(ctx, id); err != nil {
+		return fmt.Errorf("failed to delete session info: %w", err)
+	}
+	// flushes the events still buffered for the stream first, which would
+	// otherwise recreate it
+	s.closeQueue()
+	s.forget()
+	// deleting the stream drops every member's consumer group with it
+	if err := s.queue.DeleteTopic(ctx, "ses_"+id); err != nil {
+		return fmt.Errorf("failed to delete session stream: %w", err)
+	}
+	return nil
 }
 
 func (s *BaseSession) SendEvent(ctx context.Context, e wsmodels.Event) {
-	if s.currentSession == nil {
+	if _, ok := s.self(); !ok {
+		return
+	}
+	if err := s.authorize(ctx, e); err != nil {
+		s.deny(ctx, err)
 		return
 	}
-	if e.SenderID == "" {
-		e.SenderID = s.currentSession.Self.Id
+	s.publish(ctx, e)
+	s.wake(ctx)
+}
+
+// publish writes an authorized event to the session's stream.
+func (s *BaseSession) publish(ctx context.Context, e wsmodels.Event) {
+	self, ok := s.self()
+	if !ok {
+		return
+	}
+	// events always come from the user this session belongs to
+	e.SenderID = self.Id
+	if e.ID == "" {
+		e.ID = uuid.New().String()
+	}
+	err := s.persist(ctx, e)
+	if errors.Is(err, errSessionNotFound) {
+		// another member ended the session; publishing would recreate its
+		// stream
+		slog.Info("dropping event of ended session", "event", e.Type)
+		return
 	}
+	if err != nil {
+		slog.Error("failed to persist session state", "err", err, "event", e.Type)
+	}
+	s.stateMu.Lock()
 	s.lastEventSent = time.Now()
+	s.stateMu.Unlock()
 
 	select {
 	case <-ctx.Done():
@@ -207,27 +488,27 @@ func (s *BaseSession) SendEvent(ctx context.Context, e wsmodels.Event) {
 	default:
 		slog.Error("channel full")
 	}
-	if s.currentSession.Self.Status == wsmodels.StatusIdle {
-		s.currentSession.Self.Status = wsmodels.StatusConnected
-		es := wsmodels.Event{
-			Type:    wsmodels.EventTypeUserDataChanged,
-			Message: "",
-			Remote:  false,
-		}
-		err := es.Set(s.currentSession.Self)
-		if err != nil {
-			slog.Error("failed to set user data", "err", err)
-			return
-		}
-		select {
-		case <-ctx.Done():
-			return
-		case s.produceChan <- es:
-			return
-		default:
-			slog.Error("channel full")
+}
+
+// wake marks an idle user connected again once they send something and
+// tells the other members.
+func (s *BaseSession) wake(ctx context.Context) {
+	woke := false
+	self, ok := s.updateSelf(func(u *wsmodels.User) {
+		if u.Status == wsmodels.StatusIdle {
+			u.Status = wsmodels.StatusConnected
+			woke = true
 		}
+	})
+	if !ok || !woke {
+		return
+	}
+	e := wsmodels.Event{Type: wsmodels.EventTypeUserDataChanged}
+	if err := e.Set(self); err != nil {
+		slog.Error("failed to set user data", "err", err)
+		return
 	}
+	s.publish(ctx, e)
 }
 
 func (s *BaseSession) GetEvent() chan<- wsmodels.Event {
@@ -236,31 +517,73 @@ func (s *BaseSession) GetEvent() chan<- wsmodels.Event {
 
 func (s *BaseSession) WsHandler(h WsHandler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		conn, err := upgrader.Upgrade(w, r, nil)
+		if s.authenticator != nil && s.verified == nil {
+			slog.Info("rejected unauthenticated websocket connection")
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		u := s.upgrader
+		u.Subprotocols = s.subprotocols()
+		conn, err := u.Upgrade(w, r, nil)
 		if err != nil {
 			slog.Error("failed to upgrade websocket connection:", "err", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		if u.EnableCompression {
+			if err := conn.SetCompressionLevel(s.compressionLevel); err != nil {
+				slog.Error("invalid compression level", "err", err, "level", s.compressionLevel)
+			}
+		}
 		defer conn.Close()
 		defer s.Disconnect()
-		if s.currentSession == nil {
+		codec, ok := s.negotiatedCodec(r, conn)
+		if !ok {
+			slog.Error("unsupported subprotocol", "requested", websocket.Subprotocols(r))
+			s.rejectSubprotocol(conn)
+			return
+		}
+		// the user's Id and Name stay the same while connected
+		self, ok := s.self()
+		if !ok {
 			slog.Error("session not initialized")
 			return
 		}
+		// whichever goroutine stops first cancels ctx and takes the rest
+		// down with it; Disconnect only runs once all of them returned
 		ctx, cancel := context.WithCancel(r.Context())
 		defer cancel()
 		wg := sync.WaitGroup{}
+		go func() {
+			// unblocks the inbound reader
+			<-ctx.Done()
+			_ = conn.Close()
+		}()
+		s.startHeartbeat(conn)
+		if s.readLimit > 0 {
+			conn.SetReadLimit(s.readLimit)
+		}
+		s.sendSnapshot(ctx)
+		s.sendHistory(ctx)
+		replay, resumedTo := s.resume(ctx, lastEventID(r))
 
 		/*
 			All of the bellow logic is for local ws connection data todo setup sesion listeners
 
 		*/
 		slog.Info("starting ws handler")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.hostLoop(ctx)
+		}()
+
 		wg.Add(1)
 		go func() {
 			// listen to queue
 			defer wg.Done()
+			defer cancel()
 			defer fmt.Println("finished consumeChan ")
 			slog.Info("subscribed to queue")
 			for {
@@ -268,28 +591,35 @@ func (s *BaseSession) WsHandler(h WsHandler) http.HandlerFunc {
 				case <-ctx.Done():
 					slog.Info("closing queue subscription")
 					return
-				case msg, ok := <-s.consumeChan:
+				case d, ok := <-s.consumeChan:
 					if !ok {
 						slog.Error("inbound channel closed")
 						return
 					}
-					slog.Info("received event from queue", ": ", msg, "u", s.currentSession.Self.Name)
+					slog.Info("received event from queue", ": ", d.Event, "u", self.Name)
 
 					//todo pre processing
-					msg.Remote = true
-					if msg.ReceiverID != "" && msg.ReceiverID != s.currentSession.Self.Id {
+					d.Event.Remote = true
+					msg := d.Event
+					if msg.ReceiverID != "" && msg.ReceiverID != self.Id {
+						ack(ctx, d)
+						continue
+					}
+					if s.resolve(msg) {
+						ack(ctx, d)
 						continue
 					}
 					if s.processEvent(msg) {
 						slog.Info("event processed", "event", msg.Type, "remote", msg.Remote, "sender", msg.SenderID, "receiver", msg.ReceiverID)
+						ack(ctx, d)
 						continue
 					}
+					// a slow client holds back the queue rather than
+					// bouncing the delivery off a full channel
 					select {
 					case <-ctx.Done():
 						return
-					case s.wsOutbound <- msg:
-					default:
-						slog.Error("outbound channel full")
+					case s.wsOutbound <- d:
 					}
 				}
 			}
@@ -301,29 +631,31 @@ func (s *BaseSession) WsHandler(h WsHandler) http.HandlerFunc {
 			defer ticker.Stop()
 			defer fmt.Println("finished ticker")
 			defer wg.Done()
+			defer cancel()
 			for {
 				select {
 				case <-ctx.Done():
 					return
 				case <-ticker.C:
-					if s.currentSession == nil {
+					if _, ok := s.self(); !ok {
 						return
 					}
-					if time.Since(s.lastEventSent) > s.currentSession.IdleDuration && s.currentSession.Self.Status == wsmodels.StatusConnected {
-						s.currentSession.Self.Status = wsmodels.StatusIdle
-						slog.Info("user idle for too long", "user", s.currentSession.Self.Name, "time", time.Since(s.lastEventSent))
-						e := wsmodels.Event{
-							Type:    wsmodels.EventTypeUserDataChanged,
-							Message: "",
-							Remote:  false,
-						}
-						err := e.Set(s.currentSession.Self)
-						if err != nil {
-							slog.Error("failed to set user data", "err", err)
-							return
-						}
-						s.SendEvent(ctx, e)
+					idle, ok := s.markIdle()
+					if !ok {
+						continue
+					}
+					slog.Info("user idle for too long", "user", idle.Name)
+					e := wsmodels.Event{
+						Type:    wsmodels.EventTypeUserDataChanged,
+						Message: "",
+						Remote:  false,
+					}
+					err := e.Set(idle)
+					if err != nil {
+						slog.Error("failed to set user data", "err", err)
+						return
 					}
+					s.publish(ctx, e)
 				}
 			}
 
@@ -333,22 +665,61 @@ func (s *BaseSession) WsHandler(h WsHandler) http.HandlerFunc {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
+			defer cancel()
 			defer fmt.Println("finished outbound")
+			var ping <-chan time.Time
+			if s.pongWait > 0 && s.pingInterval > 0 {
+				ticker := time.NewTicker(s.pingInterval)
+				defer ticker.Stop()
+				ping = ticker.C
+			}
+			if err := s.flushDirect(conn, codec); err != nil {
+				slog.Error("failed to write event:", "err", err)
+				return
+			}
+			for _, entry := range replay {
+				_ = conn.SetWriteDeadline(s.writeDeadline())
+				if err := writeEvent(conn, codec, entry.Event); err != nil {
+					slog.Error("failed to write event:", "err", err)
+					return
+				}
+				h(w, r, entry.Event)
+			}
 			for {
 				select {
 				case <-ctx.Done():
 					return
-				case event, ok := <-s.wsOutbound:
+				case <-ping:
+					if err := conn.WriteControl(websocket.PingMessage, nil, s.writeDeadline()); err != nil {
+						slog.Error("failed to send ping", "err", err)
+						return
+					}
+				case e := <-s.direct:
+					_ = conn.SetWriteDeadline(s.writeDeadline())
+					if err := writeEvent(conn, codec, e); err != nil {
+						slog.Error("failed to write event:", "err", err)
+						return
+					}
+				case d, ok := <-s.wsOutbound:
 					if !ok {
 						slog.Info("outbound channel closed")
 						return
 					}
-					err := conn.WriteJSON(event)
+					if resumedTo != "" && wsqueue.CompareIDs(d.ID, resumedTo) <= 0 {
+						// already sent by the replay
+						ack(ctx, d)
+						continue
+					}
+					d.Event.StreamID = d.ID
+					_ = conn.SetWriteDeadline(s.writeDeadline())
+					err := writeEvent(conn, codec, d.Event)
 					if err != nil {
 						slog.Error("failed to write event:", "err", err)
+						nack(ctx, d)
 						return
 					}
-					h(w, r, event)
+					ack(ctx, d)
+					h(w, r, d.Event)
 				}
 			}
 		}()
@@ -357,43 +728,45 @@ func (s *BaseSession) WsHandler(h WsHandler) http.HandlerFunc {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
+			defer cancel()
 			defer fmt.Println("finished inbound")
 			for {
-				var event wsmodels.Event
-				err := conn.ReadJSON(&event)
-				if err != nil {
-					slog.Error("failed to read JSON message:", "err", err, "")
-					s.Disconnect()
-					_ = conn.Close()
-					return
-				}
-				if s.processEvent(event) {
+				event, err := readEvent(conn, codec)
+				if errors.Is(err, errDecode) {
+					slog.Error("dropping inbound message", "err", err)
 					continue
 				}
-				s.lastEventSent = time.Now()
-				s.SendEvent(ctx, event)
-
-				if s.currentSession.Self.Status == wsmodels.StatusIdle {
-					s.currentSession.Self.Status = wsmodels.StatusConnected
-					es := wsmodels.Event{
-						Type:    wsmodels.EventTypeUserDataChanged,
-						Message: "",
-						Remote:  false,
-					}
-					err := es.Set(s.currentSession.Self)
-					if err != nil {
-						slog.Error("failed to set user data", "err", err)
-						continue
+				if err != nil {
+					var netErr net.Error
+					if errors.As(err, &netErr) && netErr.Timeout() {
+						// Disconnect announces the user as gone
+						slog.Info("heartbeat timed out", "user", self.Name)
+						s.updateSelf(func(u *wsmodels.User) { u.Status = wsmodels.StatusDisconnected })
+					} else if ctx.Err() == nil {
+						slog.Error("failed to read message:", "err", err)
 					}
-					select {
-					case <-ctx.Done():
+					return
+				}
+				s.touch(conn)
+				if reason := s.limited(ctx); reason != "" {
+					if !s.violate(ctx, conn, reason) {
 						return
-					case s.produceChan <- es:
-						continue
-					default:
-						slog.Error("channel full")
 					}
+					continue
+				}
+				s.checkInbound(&event)
+				if err := s.authorizeInbound(ctx, event); err != nil {
+					s.deny(ctx, err)
+					continue
+				}
+				if !s.route(ctx, event) {
+					continue
 				}
+				if s.processEvent(event) {
+					continue
+				}
+				s.publish(ctx, event)
+				s.wake(ctx)
 			}
 		}()
 		wg.Wait()
@@ -401,13 +774,149 @@ func (s *BaseSession) WsHandler(h WsHandler) http.HandlerFunc {
 	}
 }
 
+// startHeartbeat arms the read deadline of conn; every pong pushes it back
+// and records the user as seen.
+func (s *BaseSession) startHeartbeat(conn *websocket.Conn) {
+	if s.pongWait <= 0 {
+		return
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(s.pongWait))
+	conn.SetPongHandler(func(string) error {
+		s.touch(conn)
+		return nil
+	})
+}
+
+// touch records activity from the client and extends the read deadline.
+func (s *BaseSession) touch(conn *websocket.Conn) {
+	s.updateSelf(func(u *wsmodels.User) { u.LastSeen = time.Now().Unix() })
+	if s.pongWait > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(s.pongWait))
+	}
+}
+
+// markIdle marks a connected user idle once they sent nothing for the
+// session's IdleDuration and returns the updated user, or false when they
+// stay as they are.
+func (s *BaseSession) markIdle() (wsmodels.User, bool) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if s.currentSession == nil || s.currentSession.Self.Status != wsmodels.StatusConnected ||
+		time.Since(s.lastEventSent) <= s.currentSession.IdleDuration {
+		return wsmodels.User{}, false
+	}
+	s.currentSession.Self.Status = wsmodels.StatusIdle
+	return s.currentSession.Self, true
+}
+
+// writeDeadline returns the deadline for a write started now, or the zero
+// time when writes are unbounded.
+func (s *BaseSession) writeDeadline() time.Time {
+	if s.writeWait <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(s.writeWait)
+}
+
+// checkInbound stamps an event from the client with its verified sender.
+func (s *BaseSession) checkInbound(e *wsmodels.Event) {
+	self, _ := s.self()
+	e.SenderID = self.Id
+	e.Remote = false
+	e.StreamID = ""
+	if e.ID == "" {
+		e.ID = uuid.New().String()
+	}
+}
+
+// limited returns why the next inbound message breaks a rate limit, or ""
+// when it may be sent.
+func (s *BaseSession) limited(ctx context.Context) string {
+	allowed, err := s.allowUser(ctx)
+	if err != nil {
+		slog.Error("failed to check user rate limit", "err", err)
+	}
+	if !allowed {
+		return "user rate limit exceeded"
+	}
+	allowed, err = s.allowSession(ctx)
+	if err != nil {
+		slog.Error("failed to check session rate limit", "err", err)
+	}
+	if !allowed {
+		return "session rate limit exceeded"
+	}
+	return ""
+}
+
+// violate applies the violation policy to a rejected message and reports
+// whether the connection stays open.
+func (s *BaseSession) violate(ctx context.Context, conn *websocket.Conn, reason string) bool {
+	self, _ := s.self()
+	slog.Info("rejected inbound message", "reason", reason, "user", self.Name)
+	switch s.violation {
+	case ViolationDrop:
+	case ViolationClose:
+		_ = conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason),
+			s.writeDeadline())
+		return false
+	default:
+		s.reply(ctx, wsmodels.Event{Type: wsmodels.EventTypeError, Message: reason})
+	}
+	return true
+}
+
+// reply sends e to this client only, bypassing the queue.
+func (s *BaseSession) reply(ctx context.Context, e wsmodels.Event) {
+	if self, ok := s.self(); ok {
+		e.ReceiverID = self.Id
+	}
+	select {
+	case <-ctx.Done():
+	case s.direct <- e:
+	default:
+		slog.Error("direct channel full", "type", e.Type)
+	}
+}
+
+// writeEvent encodes e with codec and writes it as a single frame.
+func writeEvent(conn *websocket.Conn, codec wsmodels.Codec, e wsmodels.Event) error {
+	data, err := codec.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	messageType := websocket.TextMessage
+	if codec.Binary() {
+		messageType = websocket.BinaryMessage
+	}
+	return conn.WriteMessage(messageType, data)
+}
+
+// errDecode marks frames that were read but could not be decoded; the
+// connection itself is still usable.
+var errDecode = errors.New("failed to decode event")
+
+// readEvent reads the next frame and decodes it with codec.
+func readEvent(conn *websocket.Conn, codec wsmodels.Codec) (wsmodels.Event, error) {
+	var e wsmodels.Event
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return e, err
+	}
+	if err := codec.Unmarshal(data, &e); err != nil {
+		return e, fmt.Errorf("%w: %w", errDecode, err)
+	}
+	return e, nil
+}
+
 func (s *BaseSession) processEvent(e wsmodels.Event) bool {
 	//todo do any pre processing like updating history/user data session info etc
-	// todo write to queue
-	if s.currentSession == nil {
+	// state in Redis is written by the pod that published the event, see
+	// persist; this only keeps the local copy current
+	if _, ok := s.self(); !ok {
 		return true
 	}
-	// todo if is host sync data to redis client
 	switch e.Type {
 	case wsmodels.EventTypeUserJoined:
 		user, err := wsmodels.GetDataEvent[wsmodels.User](e)
@@ -415,37 +924,63 @@ func (s *BaseSession) processEvent(e wsmodels.Event) bool {
 			slog.Error("failed to unmarshal user", "err", err, "event", e, "data", string(e.Data))
 			return true
 		}
-		s.currentSession.Users = append(s.currentSession.Users, user)
-		if !s.currentSession.Self.Host {
-			return false
+		s.upsertUser(user)
+	case wsmodels.EventTypeUserLeft:
+		user, err := wsmodels.GetDataEvent[wsmodels.User](e)
+		if err != nil {
+			slog.Error("failed to unmarshal user", "err", err, "event", e, "data", string(e.Data))
+			return true
 		}
-		key := s.ID() + "_session_info"
-		err = s.setSession(context.Background(), key, s.currentSession)
+		s.markLeft(user)
+		// the host may be gone; elect without waiting for its lease
+		select {
+		case s.electNow <- struct{}{}:
+		default:
+		}
+	case wsmodels.EventTypeHostChanged:
+		host, err := wsmodels.GetDataEvent[wsmodels.User](e)
 		if err != nil {
-			slog.Error("failed to set session info", "err", err)
+			slog.Error("failed to unmarshal host", "err", err, "event", e)
+			return true
 		}
-		return false
-	case wsmodels.EventTypeUserLeft:
-		// update s.current users
-		//todo if host left assign to next oldest user
-		//s.currentSession.Users
-	case wsmodels.EventTypeUserDataChanged:
-		// update s.current users
-		if !s.currentSession.Self.Host {
-			return false
+		s.stateMu.Lock()
+		if s.currentSession != nil {
+			for _, u := range s.currentSession.Users {
+				u.Host = u.Id == host.Id
+			}
+			if host.Id != s.currentSession.Self.Id {
+				s.currentSession.Self.Host = false
+			}
 		}
-
-	case wsmodels.EventTypeGeneral:
-		s.currentSession.History = append(s.currentSession.History, &e)
-		if !s.currentSession.Self.Host {
-			return false
+		s.stateMu.Unlock()
+	case wsmodels.EventTypeUserDataChanged:
+		user, err := wsmodels.GetDataEvent[wsmodels.User](e)
+		if err != nil {
+			slog.Error("failed to unmarshal user", "err", err, "event", e, "data", string(e.Data))
+			return true
 		}
-		key := s.ID() + "_session_info"
-		err := s.setSession(context.Background(), key, s.currentSession)
+		s.upsertUser(user)
+	case wsmodels.EventTypeMetaChanged:
+		change, err := wsmodels.GetDataEvent[wsmodels.MetaChange](e)
 		if err != nil {
-			slog.Error("failed to set session info", "err", err)
+			slog.Error("failed to unmarshal meta change", "err", err, "event", e)
+			return true
 		}
-		return false
+		s.applyMeta(*change)
+	case wsmodels.EventTypeGeneral:
+		s.appendHistory(e)
 	}
 	return false
 }
+
+func ack(ctx context.Context, d wsqueue.Delivery[wsmodels.Event]) {
+	if err := d.Ack(ctx); err != nil {
+		slog.Error("failed to ack event", "err", err, "id", d.ID)
+	}
+}
+
+func nack(ctx context.Context, d wsqueue.Delivery[wsmodels.Event]) {
+	if err := d.Nack(ctx); err != nil {
+		slog.Error("failed to nack event", "err", err, "id", d.ID)
+	}
+}
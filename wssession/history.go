@@ -0,0 +1,90 @@
+package wssession
+
+import (
+	"context"
+	"fmt"
+	"github.com/Seann-Moser/multiws/wsmodels"
+	"log/slog"
+)
+
+// GetHistory returns up to limit events published before the event with ID
+// before, oldest first, for "load earlier messages". An empty before
+// returns the most recent events. Only the last Session.MaxHistory events
+// are kept.
+func (s *BaseSession) GetHistory(ctx context.Context, before string, limit int) ([]*wsmodels.Event, error) {
+	if _, ok := s.self(); !ok {
+		return nil, fmt.Errorf("GetHistory: session not initialized")
+	}
+	if limit <= 0 {
+		return nil, nil
+	}
+	events, err := s.store.history(ctx, s.ID(), before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("GetHistory: %w", err)
+	}
+	return events, nil
+}
+
+// maxRecentHistory caps the history sent to a client on connect and kept
+// in the local copy of the session; older events are paged in with
+// GetHistory.
+const maxRecentHistory = 200
+
+// recentHistory returns how many of the latest events a session with the
+// given MaxHistory sends on connect.
+func recentHistory(maxHistory int) int {
+	if maxHistory <= 0 || maxHistory > maxRecentHistory {
+		return maxRecentHistory
+	}
+	return maxHistory
+}
+
+// appendHistory adds e to the local copy of the history, which mirrors the
+// capped list in Redis.
+func (s *BaseSession) appendHistory(e wsmodels.Event) {
+	if !shared(e) {
+		return
+	}
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if s.currentSession == nil {
+		return
+	}
+	for _, h := range s.currentSession.History {
+		if e.ID != "" && h.ID == e.ID {
+			return
+		}
+	}
+	s.currentSession.History = append(s.currentSession.History, &e)
+	if n := recentHistory(s.currentSession.MaxHistory); len(s.currentSession.History) > n {
+		s.currentSession.History = s.currentSession.History[len(s.currentSession.History)-n:]
+	}
+}
+
+// sendHistory sends the recent history to this client only.
+func (s *BaseSession) sendHistory(ctx context.Context) {
+	s.stateMu.Lock()
+	limit := recentHistory(s.currentSession.MaxHistory)
+	s.stateMu.Unlock()
+	events, err := s.store.history(ctx, s.ID(), "", limit)
+	if err != nil {
+		slog.Error("failed to load history", "err", err)
+		return
+	}
+	if len(events) == 0 {
+		return
+	}
+	e := wsmodels.Event{Type: wsmodels.EventTypeHistory}
+	if err := e.Set(events); err != nil {
+		slog.Error("failed to set history", "err", err)
+		return
+	}
+	s.reply(ctx, e)
+}
+
+// shared reports whether e belongs in the session history every member
+// receives. Events addressed to one user, such as direct messages and
+// Request payloads, stay out of it.
+func shared(e wsmodels.Event) bool {
+	return e.ReceiverID == ""
+}
@@ -0,0 +1,197 @@
+package wssession
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Seann-Moser/multiws/wsmodels"
+	"github.com/redis/go-redis/v9"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// defaultHostLease is how long a host keeps the session without renewing,
+// and so how long a crashed host goes unnoticed.
+const defaultHostLease = 15 * time.Second
+
+// minHostLease keeps the lease well above the one second resolution of
+// User.LastSeen, which hostCandidate compares against it; with shorter
+// leases nobody may look live enough to be elected.
+const minHostLease = 3 * time.Second
+
+// renewLeaseScript extends the lease in KEYS[1] if ARGV[1] still holds it.
+var renewLeaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseLeaseScript deletes the lease in KEYS[1] if ARGV[1] holds it.
+var releaseLeaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// hostCandidate returns the user who should host: the one who joined first
+// among the connected users seen within the lease, falling back to idle
+// ones. Ties go to the smallest Id so every pod picks the same user.
+func hostCandidate(users []*wsmodels.User, lease time.Duration) *wsmodels.User {
+	live := make([]*wsmodels.User, 0, len(users))
+	for _, u := range users {
+		if u.Status != wsmodels.StatusDisconnected && time.Since(time.Unix(u.LastSeen, 0)) <= lease {
+			live = append(live, u)
+		}
+	}
+	sort.Slice(live, func(i, j int) bool {
+		if live[i].Joined != live[j].Joined {
+			return live[i].Joined < live[j].Joined
+		}
+		return live[i].Id < live[j].Id
+	})
+	for _, u := range live {
+		if u.Status == wsmodels.StatusConnected {
+			return u
+		}
+	}
+	if len(live) > 0 {
+		return live[0]
+	}
+	return nil
+}
+
+// hostLoop keeps this user's presence fresh and runs the host election
+// every third of the lease, or right away when the host leaves.
+func (s *BaseSession) hostLoop(ctx context.Context) {
+	ticker := time.NewTicker(s.hostLease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			self, ok := s.updateSelf(func(u *wsmodels.User) { u.LastSeen = time.Now().Unix() })
+			if !ok {
+				return
+			}
+			if _, err := s.store.setField(ctx, s.ID(), kindUsers, self.Id, &self); err != nil {
+				slog.Error("failed to refresh presence", "err", err)
+			}
+		case <-s.electNow:
+		}
+		s.elect(ctx)
+	}
+}
+
+// elect renews the lease while this user holds it, hands it over when
+// they went idle and someone else is active, and takes it over when it
+// lapsed and this user is the candidate.
+func (s *BaseSession) elect(ctx context.Context) {
+	me, ok := s.self()
+	if !ok {
+		return
+	}
+	id, self := s.ID(), me.Id
+	key := keysFor(id).host
+
+	holder, err := s.sessionCache.Get(ctx, key).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		slog.Error("failed to read host lease", "err", err)
+		return
+	}
+	session, err := s.store.load(ctx, id)
+	if err != nil {
+		slog.Error("failed to load session for host election", "err", err)
+		return
+	}
+	candidate := hostCandidate(session.Users, s.hostLease)
+
+	if holder == self {
+		if candidate != nil && candidate.Id != self && me.Status != wsmodels.StatusConnected {
+			slog.Info("handing over idle host", "user", self, "to", candidate.Id)
+			s.releaseHost(ctx)
+			return
+		}
+		renewed, err := renewLeaseScript.Run(ctx, s.sessionCache, []string{key}, self, s.hostLease.Milliseconds()).Int()
+		if err != nil {
+			slog.Error("failed to renew host lease", "err", err)
+			return
+		}
+		if renewed == 1 {
+			return
+		}
+		holder = ""
+	}
+	if holder != "" || candidate == nil || candidate.Id != self {
+		s.updateSelf(func(u *wsmodels.User) { u.Host = false })
+		return
+	}
+	acquired, err := s.sessionCache.SetNX(ctx, key, self, s.hostLease).Result()
+	if err != nil || !acquired {
+		if err != nil {
+			slog.Error("failed to acquire host lease", "err", err)
+		}
+		return
+	}
+	s.becomeHost(ctx, session.Users)
+}
+
+// becomeHost records this user as host, clears the flag of the previous
+// one and tells every member.
+func (s *BaseSession) becomeHost(ctx context.Context, users []*wsmodels.User) {
+	me, ok := s.updateSelf(func(u *wsmodels.User) { u.Host = true })
+	if !ok {
+		return
+	}
+	id, self := s.ID(), me.Id
+	slog.Info("became session host", "session", id, "user", self)
+	for _, u := range users {
+		if !u.Host || u.Id == self {
+			continue
+		}
+		_, err := s.store.updateField(ctx, id, kindUsers, u.Id, func(raw []byte) (interface{}, error) {
+			if raw == nil {
+				return nil, nil
+			}
+			var prev wsmodels.User
+			if err := json.Unmarshal(raw, &prev); err != nil {
+				return nil, err
+			}
+			prev.Host = false
+			return &prev, nil
+		})
+		if err != nil {
+			slog.Error("failed to clear previous host", "err", err, "user", u.Id)
+		}
+	}
+
+	e := wsmodels.Event{Type: wsmodels.EventTypeHostChanged}
+	if err := e.Set(me); err != nil {
+		slog.Error("failed to set host", "err", err)
+		return
+	}
+	// persists the new host along with the event
+	s.publish(ctx, e)
+}
+
+// releaseHost gives up the lease if this user holds it so the next
+// candidate can take over without waiting for it to expire.
+func (s *BaseSession) releaseHost(ctx context.Context) {
+	released := false
+	self, ok := s.updateSelf(func(u *wsmodels.User) {
+		released = u.Host
+		u.Host = false
+	})
+	if !ok || !released {
+		return
+	}
+	if _, err := releaseLeaseScript.Run(ctx, s.sessionCache, []string{keysFor(s.ID()).host}, self.Id).Result(); err != nil {
+		slog.Error("failed to release host lease", "err", err)
+	}
+	if _, err := s.store.setField(ctx, s.ID(), kindUsers, self.Id, &self); err != nil {
+		slog.Error("failed to store host change", "err", err)
+	}
+}
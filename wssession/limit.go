@@ -0,0 +1,80 @@
+package wssession
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// RateLimit is a token bucket: up to Burst messages may arrive at once, and
+// the bucket refills at Rate messages per second. A zero RateLimit means no
+// limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l RateLimit) enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// ViolationPolicy decides what happens to an inbound message that breaks a
+// rate limit.
+type ViolationPolicy int
+
+const (
+	// ViolationReply drops the message and sends the client an
+	// wsmodels.EventTypeError event explaining why.
+	ViolationReply ViolationPolicy = iota
+	// ViolationDrop silently drops the message.
+	ViolationDrop
+	// ViolationClose closes the connection with 1008 (policy violation).
+	ViolationClose
+)
+
+// bucketScript is a token bucket shared by every pod serving a session.
+// KEYS[1] holds the bucket; ARGV is rate per second, burst and the current
+// time in milliseconds. Returns 1 when a token was taken.
+var bucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1]) or burst
+local ts = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) / 1000 * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return allowed
+`)
+
+// allow takes a token from the bucket in key. Redis errors let the message
+// through rather than silencing the room.
+func (s *BaseSession) allow(ctx context.Context, key string, l RateLimit) (bool, error) {
+	if !l.enabled() {
+		return true, nil
+	}
+	allowed, err := bucketScript.Run(ctx, s.sessionCache, []string{key},
+		l.Rate, l.Burst, time.Now().UnixMilli()).Int()
+	if err != nil {
+		return true, err
+	}
+	return allowed == 1, nil
+}
+
+// allowUser takes a token from this user's bucket, shared by all of their
+// connections to the session.
+func (s *BaseSession) allowUser(ctx context.Context) (bool, error) {
+	self, _ := s.self()
+	return s.allow(ctx, s.ID()+"_rate_"+self.Id, s.userLimit)
+}
+
+// allowSession takes a token from the session-wide bucket.
+func (s *BaseSession) allowSession(ctx context.Context) (bool, error) {
+	return s.allow(ctx, s.ID()+"_rate", s.sessionLimit)
+}
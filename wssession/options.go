@@ -0,0 +1,202 @@
+package wssession
+
+import (
+	"github.com/Seann-Moser/multiws/wsmodels"
+	"github.com/gorilla/websocket"
+	"time"
+)
+
+// Option configures a BaseSession.
+type Option func(*BaseSession)
+
+// WithWireCodec sets how events are encoded on the WebSocket for clients
+// that do not negotiate a subprotocol. Binary codecs (MessagePack,
+// Protobuf) are sent as binary frames. The default is wsmodels.JSON in text
+// frames.
+func WithWireCodec(c wsmodels.Codec) Option {
+	return func(s *BaseSession) {
+		if c != nil {
+			s.wireCodec = c
+		}
+	}
+}
+
+// WithSubprotocols sets the codecs clients may select through
+// Sec-WebSocket-Protocol (see Subprotocol), in order of preference. All
+// built-in codecs are accepted by default.
+func WithSubprotocols(codecs ...wsmodels.Codec) Option {
+	return func(s *BaseSession) {
+		s.codecs = codecs
+	}
+}
+
+// WithUpgrader replaces the default websocket.Upgrader. Apply it before the
+// other upgrade options, which adjust the upgrader in place. Subprotocols
+// are always set by the session, see WithSubprotocols.
+func WithUpgrader(u websocket.Upgrader) Option {
+	return func(s *BaseSession) {
+		s.upgrader = u
+	}
+}
+
+// WithAllowedOrigins restricts which browser origins may open a WebSocket,
+// protecting against cross-site WebSocket hijacking. Patterns are exact
+// hosts ("app.example.com"), optionally with a scheme
+// ("https://app.example.com") or a port ("localhost:8080"), or wildcard
+// subdomains ("*.example.com"). Without a port any port matches. By default
+// every origin is allowed.
+func WithAllowedOrigins(patterns ...string) Option {
+	return func(s *BaseSession) {
+		s.upgrader.CheckOrigin = originChecker(patterns)
+	}
+}
+
+// WithBufferSizes sets the upgrader's read and write buffer sizes in bytes.
+func WithBufferSizes(read, write int) Option {
+	return func(s *BaseSession) {
+		s.upgrader.ReadBufferSize = read
+		s.upgrader.WriteBufferSize = write
+	}
+}
+
+// WithHandshakeTimeout bounds how long the upgrade handshake may take.
+func WithHandshakeTimeout(d time.Duration) Option {
+	return func(s *BaseSession) {
+		s.upgrader.HandshakeTimeout = d
+	}
+}
+
+// WithCompression negotiates permessage-deflate with clients that support
+// it and compresses outgoing messages at level (see compress/flate). The
+// level defaults to flate.BestSpeed when compression is enabled through
+// WithUpgrader instead.
+func WithCompression(level int) Option {
+	return func(s *BaseSession) {
+		s.upgrader.EnableCompression = true
+		s.compressionLevel = level
+	}
+}
+
+// WithHeartbeat sets how often the server pings the client and how long it
+// waits for any pong (or message) before treating the connection as dead.
+// pingInterval must be shorter than pongWait. A pongWait <= 0 disables
+// heartbeats. The default pings every 54s and waits 60s.
+func WithHeartbeat(pingInterval, pongWait time.Duration) Option {
+	return func(s *BaseSession) {
+		s.pingInterval = pingInterval
+		s.pongWait = pongWait
+	}
+}
+
+// WithWriteWait bounds how long a single write to the client may block
+// before the connection is dropped. The default is 10s.
+func WithWriteWait(d time.Duration) Option {
+	return func(s *BaseSession) {
+		s.writeWait = d
+	}
+}
+
+// WithReadLimit caps the size in bytes of a single inbound message. Larger
+// messages close the connection with 1009 (message too big). By default
+// messages are unbounded.
+func WithReadLimit(n int64) Option {
+	return func(s *BaseSession) {
+		s.readLimit = n
+	}
+}
+
+// WithUserRateLimit limits how fast a single user may send events, across
+// all of their connections to the session. The bucket lives in Redis so it
+// holds across pods.
+func WithUserRateLimit(l RateLimit) Option {
+	return func(s *BaseSession) {
+		s.userLimit = l
+	}
+}
+
+// WithSessionRateLimit limits how fast all members of a session combined
+// may send events. The bucket lives in Redis so it holds across pods.
+func WithSessionRateLimit(l RateLimit) Option {
+	return func(s *BaseSession) {
+		s.sessionLimit = l
+	}
+}
+
+// WithViolationPolicy sets how rate limit violations are handled. The
+// default is ViolationReply.
+func WithViolationPolicy(p ViolationPolicy) Option {
+	return func(s *BaseSession) {
+		s.violation = p
+	}
+}
+
+// WithAuthenticator requires each connection to be verified with
+// Authenticate before Init, which then joins the verified user. WsHandler
+// rejects connections that were not with 401 before upgrading.
+func WithAuthenticator(a Authenticator) Option {
+	return func(s *BaseSession) {
+		s.authenticator = a
+	}
+}
+
+// WithPolicy sets the policy every event and session action of this
+// session's user is checked against, e.g. NewRolePolicy. Refused events are
+// answered with a wsmodels.EventTypeDenied event. By default everything is
+// allowed except reserved system types (see wsmodels.IsSystemEvent) sent by
+// clients.
+func WithPolicy(p Policy) Option {
+	return func(s *BaseSession) {
+		s.policy = p
+	}
+}
+
+// WithRouter dispatches inbound client events to r before they are
+// published. Events that fail to decode, validate or handle are answered
+// with a wsmodels.EventTypeError event instead of being published.
+func WithRouter(r *Router) Option {
+	return func(s *BaseSession) {
+		s.router = r
+	}
+}
+
+// WithRequestTimeout bounds Request calls whose context has no deadline.
+// The default is 10s; d <= 0 waits on the context alone.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(s *BaseSession) {
+		s.requestTimeout = d
+	}
+}
+
+// WithHostLease sets how long the host holds the session between renewals.
+// A host that stops renewing, e.g. because its pod crashed, is replaced by
+// the longest connected user once the lease runs out. The default is 15s;
+// leases shorter than 3s are raised to 3s.
+func WithHostLease(d time.Duration) Option {
+	return func(s *BaseSession) {
+		if d > 0 {
+			s.hostLease = max(d, minHostLease)
+		}
+	}
+}
+
+// WithMaxHistory sets how many events sessions created through this one
+// keep in their history. Joining an existing session keeps its limit. The
+// default is 20; n <= 0 keeps everything, though connecting clients are
+// only sent the latest 200 events and page in the rest with GetHistory.
+func WithMaxHistory(n int) Option {
+	return func(s *BaseSession) {
+		s.maxHistory = n
+	}
+}
+
+// WithReplayWindow bounds how far a reconnecting client can resume: at
+// most maxEvents missed events, published no longer than maxAge ago. A
+// client further behind gets a wsmodels.EventTypeResync event instead of a
+// replay. The defaults are 500 events and 5 minutes; maxEvents <= 0
+// disables replay and maxAge <= 0 drops the age limit.
+func WithReplayWindow(maxEvents int64, maxAge time.Duration) Option {
+	return func(s *BaseSession) {
+		s.replayMax = maxEvents
+		s.replayAge = maxAge
+	}
+}
@@ -0,0 +1,67 @@
+package wssession
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// originChecker returns a CheckOrigin func accepting requests whose Origin
+// matches one of patterns. A pattern is a host ("app.example.com"), a
+// scheme and host ("https://app.example.com"), or either with a leading
+// "*." to accept any subdomain ("*.example.com", but not "example.com"
+// itself). Any port matches unless the pattern names one
+// ("localhost:8080"). Requests without an Origin header are not from a
+// browser and are accepted.
+func originChecker(patterns []string) func(r *http.Request) bool {
+	type pattern struct {
+		scheme   string
+		host     string
+		port     string
+		wildcard bool
+	}
+	parsed := make([]pattern, 0, len(patterns))
+	for _, p := range patterns {
+		var pt pattern
+		p = strings.ToLower(strings.TrimSpace(p))
+		if scheme, rest, ok := strings.Cut(p, "://"); ok {
+			pt.scheme, p = scheme, rest
+		}
+		if strings.HasPrefix(p, "*.") {
+			pt.wildcard = true
+			p = p[1:] // keep the dot: ".example.com"
+		}
+		pt.host = p
+		if u, err := url.Parse("//" + p); err == nil {
+			pt.host, pt.port = u.Hostname(), u.Port()
+		}
+		parsed = append(parsed, pt)
+	}
+
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Host == "" {
+			return false
+		}
+		scheme, host, port := strings.ToLower(u.Scheme), strings.ToLower(u.Hostname()), u.Port()
+		for _, p := range parsed {
+			if p.scheme != "" && p.scheme != scheme {
+				continue
+			}
+			if p.port != "" && p.port != port {
+				continue
+			}
+			if p.wildcard && strings.HasSuffix(host, p.host) {
+				return true
+			}
+			if !p.wildcard && host == p.host {
+				return true
+			}
+		}
+		return false
+	}
+}
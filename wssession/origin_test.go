@@ -0,0 +1,49 @@
+package wssession
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginChecker(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		origin   string
+		want     bool
+	}{
+		{"no origin header", []string{"app.example.com"}, "", true},
+		{"exact host", []string{"app.example.com"}, "https://app.example.com", true},
+		{"host is case insensitive", []string{"App.Example.com"}, "https://APP.example.com", true},
+		{"other host", []string{"app.example.com"}, "https://evil.com", false},
+		{"host as suffix of another", []string{"example.com"}, "https://evilexample.com", false},
+		{"host with any port", []string{"app.example.com"}, "https://app.example.com:8443", true},
+		{"port matches", []string{"localhost:8080"}, "http://localhost:8080", true},
+		{"port differs", []string{"localhost:8080"}, "http://localhost:9090", false},
+		{"port required", []string{"localhost:8080"}, "http://localhost", false},
+		{"scheme matches", []string{"https://app.example.com"}, "https://app.example.com", true},
+		{"scheme differs", []string{"https://app.example.com"}, "http://app.example.com", false},
+		{"scheme and port", []string{"https://app.example.com:8443"}, "https://app.example.com:8443", true},
+		{"wildcard subdomain", []string{"*.example.com"}, "https://a.example.com", true},
+		{"wildcard nested subdomain", []string{"*.example.com"}, "https://a.b.example.com", true},
+		{"wildcard excludes apex", []string{"*.example.com"}, "https://example.com", false},
+		{"wildcard suffix of another", []string{"*.example.com"}, "https://a.evilexample.com", false},
+		{"wildcard with scheme", []string{"https://*.example.com"}, "http://a.example.com", false},
+		{"wildcard with port", []string{"*.example.com:8443"}, "https://a.example.com:8443", true},
+		{"any pattern", []string{"a.com", "b.com"}, "https://b.com", true},
+		{"null origin", []string{"app.example.com"}, "null", false},
+		{"malformed origin", []string{"app.example.com"}, "://app.example.com", false},
+		{"no patterns", nil, "https://app.example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/ws", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := originChecker(tt.patterns)(r); got != tt.want {
+				t.Errorf("origin %q with %v: got %v, want %v", tt.origin, tt.patterns, got, tt.want)
+			}
+		})
+	}
+}
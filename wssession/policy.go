@@ -0,0 +1,186 @@
+package wssession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Seann-Moser/multiws/wsmodels"
+	"log/slog"
+	"slices"
+)
+
+// ActionEndSession is the pseudo event type authorized before End deletes
+// a session for everyone.
+const ActionEndSession = "EndSession"
+
+// PolicyMetaKey is the Session.Meta key holding per-session role rules, in
+// the same shape as RolePolicy rules: event type to allowed roles.
+const PolicyMetaKey = "policy"
+
+// Policy decides whether a user may publish an event or take a session
+// action (see ActionEndSession). BaseSession consults it before anything
+// reaches the queue. Clients sending reserved system types (see
+// wsmodels.IsSystemEvent) are checked too, so a policy that allows
+// everything lets them forge e.g. UserJoined events.
+type Policy interface {
+	// Authorize returns nil to allow e, or an error, usually a
+	// *DeniedError, to refuse it.
+	Authorize(ctx context.Context, session *wsmodels.Session, user *wsmodels.User, e wsmodels.Event) error
+}
+
+// PolicyFunc adapts a function to Policy.
+type PolicyFunc func(ctx context.Context, session *wsmodels.Session, user *wsmodels.User, e wsmodels.Event) error
+
+func (f PolicyFunc) Authorize(ctx context.Context, session *wsmodels.Session, user *wsmodels.User, e wsmodels.Event) error {
+	return f(ctx, session, user, e)
+}
+
+// DeniedError is returned by policies that refuse an event. It is sent to
+// the client as a wsmodels.EventTypeDenied event.
+type DeniedError struct {
+	wsmodels.Denial
+}
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("%s denied: %s", e.EventType, e.Reason)
+}
+
+// RolePolicy allows each event type only to the roles listed for it (see
+// wsmodels.User.Roles). Rules in the session's Meta under PolicyMetaKey
+// override the policy's own rules type by type. The "*" rule applies to
+// every type without a rule of its own, except reserved system types, which
+// are refused unless an explicit rule allows them. Other types without any
+// rule are allowed.
+type RolePolicy struct {
+	rules map[string][]string
+}
+
+// NewRolePolicy returns a RolePolicy with default rules, for example
+// {"General": {"host", "member"}} to mute viewers. Ending the session is
+// limited to the host unless rules say otherwise.
+func NewRolePolicy(rules map[string][]string) *RolePolicy {
+	p := &RolePolicy{rules: map[string][]string{
+		ActionEndSession: {wsmodels.RoleHost},
+	}}
+	for t, roles := range rules {
+		p.rules[t] = roles
+	}
+	return p
+}
+
+func (p *RolePolicy) Authorize(_ context.Context, session *wsmodels.Session, user *wsmodels.User, e wsmodels.Event) error {
+	allowed, ok := p.rule(session, e.Type)
+	if !ok && wsmodels.IsSystemEvent(e.Type) {
+		return reservedError(e.Type)
+	}
+	if !ok {
+		return nil
+	}
+	roles := user.Roles()
+	for _, r := range roles {
+		if slices.Contains(allowed, r) {
+			return nil
+		}
+	}
+	return &DeniedError{wsmodels.Denial{
+		EventType: e.Type,
+		Reason:    fmt.Sprintf("requires one of the roles %v", allowed),
+		Roles:     roles,
+	}}
+}
+
+// rule returns the roles allowed to send eventType, preferring the
+// session's own rules.
+func (p *RolePolicy) rule(session *wsmodels.Session, eventType string) ([]string, bool) {
+	var sessionRules map[string][]string
+	if session != nil {
+		sessionRules = metaRules(session.Meta[PolicyMetaKey])
+	}
+	for _, rules := range []map[string][]string{sessionRules, p.rules} {
+		if roles, ok := rules[eventType]; ok {
+			return roles, true
+		}
+	}
+	if wsmodels.IsSystemEvent(eventType) || eventType == ActionEndSession {
+		return nil, false
+	}
+	for _, rules := range []map[string][]string{sessionRules, p.rules} {
+		if roles, ok := rules["*"]; ok {
+			return roles, true
+		}
+	}
+	return nil, false
+}
+
+// metaRules reads rules stored in Session.Meta, which after a round trip
+// through Redis are plain JSON values.
+func metaRules(v interface{}) map[string][]string {
+	switch rules := v.(type) {
+	case map[string][]string:
+		return rules
+	case map[string]interface{}:
+		out := make(map[string][]string, len(rules))
+		for t, roles := range rules {
+			list, ok := roles.([]interface{})
+			if !ok {
+				slog.Error("invalid session policy rule", "type", t, "roles", roles)
+				continue
+			}
+			for _, r := range list {
+				if s, ok := r.(string); ok {
+					out[t] = append(out[t], s)
+				}
+			}
+			if out[t] == nil {
+				// an empty list allows nobody
+				out[t] = []string{}
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// authorize checks e against the session's policy on behalf of its user.
+func (s *BaseSession) authorize(ctx context.Context, e wsmodels.Event) error {
+	if s.policy == nil {
+		return nil
+	}
+	session, ok := s.snapshot()
+	if !ok {
+		return nil
+	}
+	return s.policy.Authorize(ctx, &session, &session.Self, e)
+}
+
+// authorizeInbound checks an event sent by the client. Without a policy,
+// reserved system types are refused.
+func (s *BaseSession) authorizeInbound(ctx context.Context, e wsmodels.Event) error {
+	if s.policy == nil && wsmodels.IsSystemEvent(e.Type) {
+		return reservedError(e.Type)
+	}
+	return s.authorize(ctx, e)
+}
+
+func reservedError(eventType string) *DeniedError {
+	return &DeniedError{wsmodels.Denial{
+		EventType: eventType,
+		Reason:    "reserved for the server",
+	}}
+}
+
+// deny tells the client why its event was refused.
+func (s *BaseSession) deny(ctx context.Context, err error) {
+	self, _ := s.self()
+	slog.Info("policy denied event", "err", err, "user", self.Name)
+	var denied *DeniedError
+	if !errors.As(err, &denied) {
+		denied = &DeniedError{wsmodels.Denial{Reason: err.Error()}}
+	}
+	e := wsmodels.Event{Type: wsmodels.EventTypeDenied, Message: denied.Error()}
+	if err := e.Set(denied.Denial); err != nil {
+		slog.Error("failed to set denial", "err", err)
+		return
+	}
+	s.reply(ctx, e)
+}
@@ -0,0 +1,79 @@
+package wssession
+
+import (
+	"context"
+	"fmt"
+	"github.com/Seann-Moser/multiws/wsmodels"
+	"github.com/google/uuid"
+	"time"
+)
+
+// defaultRequestTimeout bounds Request when ctx has no deadline.
+const defaultRequestTimeout = 10 * time.Second
+
+// pendingRequest waits for the reply to a Request.
+type pendingRequest struct {
+	receiverID string
+	reply      chan wsmodels.Event
+}
+
+// Request sends e to receiverID and waits for the event answering it, one
+// whose CorrelationID is e's ID (see wsmodels.Event.Reply). Replies travel
+// through the session stream like any event and are picked up by the
+// connection that asked, so Request only returns while WsHandler is
+// serving. Without a deadline on ctx the request times out after the
+// session's request timeout.
+func (s *BaseSession) Request(ctx context.Context, receiverID string, e wsmodels.Event) (wsmodels.Event, error) {
+	self, ok := s.self()
+	if !ok {
+		return wsmodels.Event{}, fmt.Errorf("Request: session not initialized")
+	}
+	if _, ok := ctx.Deadline(); !ok && s.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.requestTimeout)
+		defer cancel()
+	}
+	e.ID = uuid.New().String()
+	e.ReceiverID = receiverID
+	e.SenderID = self.Id
+	if err := s.authorize(ctx, e); err != nil {
+		return wsmodels.Event{}, fmt.Errorf("Request: %w", err)
+	}
+
+	p := pendingRequest{receiverID: receiverID, reply: make(chan wsmodels.Event, 1)}
+	s.pendingMu.Lock()
+	s.pending[e.ID] = p
+	s.pendingMu.Unlock()
+	defer func() {
+		s.pendingMu.Lock()
+		delete(s.pending, e.ID)
+		s.pendingMu.Unlock()
+	}()
+
+	s.publish(ctx, e)
+	select {
+	case <-ctx.Done():
+		return wsmodels.Event{}, fmt.Errorf("Request: no reply to %s: %w", e.Type, ctx.Err())
+	case reply := <-p.reply:
+		return reply, nil
+	}
+}
+
+// resolve hands e to the Request waiting for it and reports whether one
+// was. Only the user a request was sent to can answer it.
+func (s *BaseSession) resolve(e wsmodels.Event) bool {
+	if e.CorrelationID == "" {
+		return false
+	}
+	s.pendingMu.Lock()
+	p, ok := s.pending[e.CorrelationID]
+	if ok && p.receiverID == e.SenderID {
+		delete(s.pending, e.CorrelationID)
+	}
+	s.pendingMu.Unlock()
+	if !ok || p.receiverID != e.SenderID {
+		return false
+	}
+	p.reply <- e
+	return true
+}
@@ -0,0 +1,117 @@
+package wssession
+
+import (
+	"context"
+	"github.com/Seann-Moser/multiws/wsmodels"
+	"github.com/Seann-Moser/multiws/wsqueue"
+	"github.com/gorilla/websocket"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// A reconnecting client names the StreamID of the last event it received
+// in the LastEventIDParam query parameter, or the LastEventIDHeader header
+// where it can set headers.
+const (
+	LastEventIDParam  = "last_event_id"
+	LastEventIDHeader = "Last-Event-ID"
+)
+
+const (
+	defaultReplayMax = 500
+	defaultReplayAge = 5 * time.Minute
+)
+
+// lastEventID returns the StreamID the client resumes after, or "" for a
+// fresh connection.
+func lastEventID(r *http.Request) string {
+	if id := r.URL.Query().Get(LastEventIDParam); id != "" {
+		return id
+	}
+	return r.Header.Get(LastEventIDHeader)
+}
+
+// missed reads the events published to the session after lastID. It
+// reports false when they cannot all be replayed: the queue keeps no log,
+// lastID is outside the replay window or was already trimmed from the
+// stream.
+func (s *BaseSession) missed(ctx context.Context, lastID string) ([]wsqueue.Entry[wsmodels.Event], bool) {
+	rq, ok := s.queue.(wsqueue.ReplayQueue[wsmodels.Event])
+	if !ok || s.replayMax <= 0 {
+		return nil, false
+	}
+	if s.replayAge > 0 {
+		at, ok := wsqueue.IDTime(lastID)
+		if !ok || time.Since(at) > s.replayAge {
+			return nil, false
+		}
+	}
+	topic := "ses_" + s.ID()
+	oldest, err := rq.Replay(ctx, topic, "", 1)
+	if err != nil {
+		slog.Error("failed to read stream for replay", "err", err)
+		return nil, false
+	}
+	if len(oldest) > 0 && wsqueue.CompareIDs(oldest[0].ID, lastID) > 0 {
+		// entries after lastID were trimmed
+		return nil, false
+	}
+	entries, err := rq.Replay(ctx, topic, lastID, s.replayMax+1)
+	if err != nil {
+		slog.Error("failed to read missed events", "err", err)
+		return nil, false
+	}
+	if int64(len(entries)) > s.replayMax {
+		return nil, false
+	}
+	return entries, true
+}
+
+// resume prepares the replay for a client reconnecting after lastID. It
+// returns the entries to write before live delivery and the ID up to
+// which live deliveries were already covered, or tells the client to
+// resync when the gap cannot be replayed.
+func (s *BaseSession) resume(ctx context.Context, lastID string) ([]wsqueue.Entry[wsmodels.Event], string) {
+	if lastID == "" {
+		return nil, ""
+	}
+	self, _ := s.self()
+	entries, ok := s.missed(ctx, lastID)
+	if !ok {
+		slog.Info("cannot replay missed events", "user", self.Name, "last", lastID)
+		s.reply(ctx, wsmodels.Event{Type: wsmodels.EventTypeResync})
+		return nil, ""
+	}
+	replay := entries[:0]
+	for _, entry := range entries {
+		if entry.Event.ReceiverID != "" && entry.Event.ReceiverID != self.Id {
+			continue
+		}
+		entry.Event.Remote = true
+		entry.Event.StreamID = entry.ID
+		replay = append(replay, entry)
+	}
+	last := lastID
+	if len(entries) > 0 {
+		last = entries[len(entries)-1].ID
+	}
+	slog.Info("replaying missed events", "user", self.Name, "count", len(replay))
+	return replay, last
+}
+
+// flushDirect writes the events already waiting on the direct channel, so
+// the snapshot and history reach the client before any replay.
+func (s *BaseSession) flushDirect(conn *websocket.Conn, codec wsmodels.Codec) error {
+	for {
+		select {
+		case e := <-s.direct:
+			_ = conn.SetWriteDeadline(s.writeDeadline())
+			if err := writeEvent(conn, codec, e); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package wssession
+
+import (
+	"context"
+	"github.com/Seann-Moser/multiws/wsmodels"
+	"log/slog"
+	"maps"
+)
+
+// upsertUser replaces the roster entry with u's Id, or adds u if the user
+// is new, so reconnects never show up twice.
+func (s *BaseSession) upsertUser(u *wsmodels.User) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if s.currentSession == nil {
+		return
+	}
+	for i, existing := range s.currentSession.Users {
+		if existing.Id == u.Id {
+			s.currentSession.Users[i] = u
+			return
+		}
+	}
+	s.currentSession.Users = append(s.currentSession.Users, u)
+}
+
+// markLeft keeps a user who left on the roster as disconnected, the same
+// way the store records them.
+func (s *BaseSession) markLeft(u *wsmodels.User) {
+	left := *u
+	left.Status = wsmodels.StatusDisconnected
+	left.Host = false
+	s.upsertUser(&left)
+}
+
+// sendSnapshot refreshes the session from Redis and sends it to this
+// client only, so a new connection starts from the full roster and meta.
+func (s *BaseSession) sendSnapshot(ctx context.Context) {
+	if err := s.Reload(ctx); err != nil {
+		slog.Error("failed to reload session for snapshot", "err", err)
+	}
+	snapshot, ok := s.snapshot()
+	if !ok {
+		return
+	}
+	// the snapshot describes who is here, not what was said
+	snapshot.History = nil
+
+	e := wsmodels.Event{Type: wsmodels.EventTypeSessionSnapshot}
+	if err := e.Set(snapshot); err != nil {
+		slog.Error("failed to set session snapshot", "err", err)
+		return
+	}
+	s.reply(ctx, e)
+}
+
+// snapshot copies the local session so it can be read without holding
+// stateMu while other goroutines keep updating it. It returns false before
+// Init and after Disconnect.
+func (s *BaseSession) snapshot() (wsmodels.Session, bool) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if s.currentSession == nil {
+		return wsmodels.Session{}, false
+	}
+	snapshot := *s.currentSession
+	snapshot.Users = append([]*wsmodels.User(nil), s.currentSession.Users...)
+	snapshot.Meta = maps.Clone(s.currentSession.Meta)
+	return snapshot, true
+}
@@ -0,0 +1,108 @@
+package wssession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Seann-Moser/multiws/wsmodels"
+	"sync"
+)
+
+// ErrConsumed is returned by route handlers that handled an event
+// completely, so it is not published to the rest of the session.
+var ErrConsumed = errors.New("event consumed")
+
+// Validator is implemented by event payloads that check themselves after
+// decoding.
+type Validator interface {
+	Validate() error
+}
+
+// Router dispatches inbound client events to handlers registered with On,
+// keyed by event type.
+type Router struct {
+	codec  wsmodels.Codec
+	mu     sync.RWMutex
+	routes map[string]func(ctx context.Context, sess Session, e wsmodels.Event) error
+}
+
+// NewRouter returns a router decoding event data as JSON, the encoding
+// used by wsmodels.Event.Set.
+func NewRouter() *Router {
+	return NewRouterWith(wsmodels.JSON)
+}
+
+// NewRouterWith returns a router decoding event data with codec.
+func NewRouterWith(codec wsmodels.Codec) *Router {
+	return &Router{
+		codec:  codec,
+		routes: map[string]func(ctx context.Context, sess Session, e wsmodels.Event) error{},
+	}
+}
+
+// On registers handler for events of eventType. Their Data is decoded into
+// a T and validated (see Validator) before handler runs; the event itself
+// is available through EventFromContext. Registering a type again replaces
+// its handler.
+func On[T any](r *Router, eventType string, handler func(ctx context.Context, sess Session, v *T) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes[eventType] = func(ctx context.Context, sess Session, e wsmodels.Event) error {
+		v, err := wsmodels.GetDataEventWith[T](r.codec, e)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", eventType, err)
+		}
+		if val, ok := any(v).(Validator); ok {
+			if err := val.Validate(); err != nil {
+				return fmt.Errorf("invalid %s: %w", eventType, err)
+			}
+		}
+		return handler(withEvent(ctx, e), sess, v)
+	}
+}
+
+// Dispatch runs the handler registered for e.Type. handled is false when
+// there is none. Handlers returning ErrConsumed are reported as handled
+// without error.
+func (r *Router) Dispatch(ctx context.Context, sess Session, e wsmodels.Event) (handled bool, err error) {
+	r.mu.RLock()
+	route, ok := r.routes[e.Type]
+	r.mu.RUnlock()
+	if !ok {
+		return false, nil
+	}
+	return true, route(ctx, sess, e)
+}
+
+type eventKey struct{}
+
+func withEvent(ctx context.Context, e wsmodels.Event) context.Context {
+	return context.WithValue(ctx, eventKey{}, e)
+}
+
+// EventFromContext returns the event being handled by a route handler.
+func EventFromContext(ctx context.Context) (wsmodels.Event, bool) {
+	e, ok := ctx.Value(eventKey{}).(wsmodels.Event)
+	return e, ok
+}
+
+// route passes an inbound event through the session's router and reports
+// whether it should still be published. Failures are sent back to the
+// client as error events.
+func (s *BaseSession) route(ctx context.Context, e wsmodels.Event) bool {
+	if s.router == nil {
+		return true
+	}
+	handled, err := s.router.Dispatch(ctx, s, e)
+	if !handled {
+		return true
+	}
+	if errors.Is(err, ErrConsumed) {
+		return false
+	}
+	if err != nil {
+		s.reply(ctx, wsmodels.Event{Type: wsmodels.EventTypeError, Message: err.Error()})
+		return false
+	}
+	return true
+}
@@ -11,14 +11,28 @@ type WsHandler func(w http.ResponseWriter, r *http.Request, receiveEvent wsmodel
 type Session interface {
 	ID() string
 	Status() string
+	// Authenticate verifies the handshake request before Init.
+	Authenticate(r *http.Request) (*wsmodels.User, error)
+	// Init joins user to the session, creating it if needed. The user needs
+	// an ID; a nil user attaches without joining.
 	Init(ctx context.Context, sessionID string, user *wsmodels.User) error
 
 	User() *wsmodels.User
 	ListUsers() []*wsmodels.User
 	Disconnect()
+	// End deletes the session state and its event stream for every member.
+	End(ctx context.Context) error
+	// Reload refreshes the local copy of the session state from Redis.
+	Reload(ctx context.Context) error
+	// SetMeta and UpdateMeta change the shared Session.Meta atomically.
+	SetMeta(ctx context.Context, key string, value interface{}) error
+	UpdateMeta(ctx context.Context, key string, fn func(current interface{}) (interface{}, error)) error
 
-	GetHistory() []*wsmodels.Event
+	// GetHistory pages backwards through the session history.
+	GetHistory(ctx context.Context, before string, limit int) ([]*wsmodels.Event, error)
 	SendEvent(ctx context.Context, e wsmodels.Event)
+	// Request sends e to receiverID and waits for its reply.
+	Request(ctx context.Context, receiverID string, e wsmodels.Event) (wsmodels.Event, error)
 	GetEvent() chan<- wsmodels.Event
 
 	WsHandler(h WsHandler) http.HandlerFunc
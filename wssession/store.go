@@ -0,0 +1,374 @@
+package wssession
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Seann-Moser/multiws/wsmodels"
+	"github.com/redis/go-redis/v9"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// sessionTTL is how long session state outlives its last change.
+const sessionTTL = 6 * time.Hour
+
+// historyPage is how many history events are read per round trip while
+// paging through the list.
+const historyPage = 100
+
+// maxUpdateAttempts bounds the retries of an optimistic update that keeps
+// losing to concurrent writers.
+const maxUpdateAttempts = 50
+
+var (
+	errSessionNotFound = errors.New("session not found")
+	errVersionConflict = errors.New("session changed concurrently")
+)
+
+// scriptErr maps the "session not found" reply of the scripts below to
+// errSessionNotFound.
+func scriptErr(err error) error {
+	var rerr redis.Error
+	if errors.As(err, &rerr) && rerr.Error() == errSessionNotFound.Error() {
+		return errSessionNotFound
+	}
+	return err
+}
+
+// sessionKeys names the Redis keys holding a session. The hash tag keeps
+// them in one cluster slot so scripts may touch them together.
+type sessionKeys struct {
+	info    string // hash: id, maxHistory, idleDuration, version
+	users   string // hash: user id -> JSON user
+	meta    string // hash: meta key -> JSON value
+	history string // list: JSON events, newest first
+	host    string // string: id of the user holding the host lease
+}
+
+func keysFor(id string) sessionKeys {
+	tag := "{" + id + "}"
+	return sessionKeys{
+		info:    tag + "_session_info",
+		users:   tag + "_session_users",
+		meta:    tag + "_session_meta",
+		history: tag + "_session_history",
+		host:    tag + "_session_host",
+	}
+}
+
+func (k sessionKeys) all() []string {
+	return []string{k.info, k.users, k.meta, k.history}
+}
+
+// Hashes setField and updateField can write to.
+const (
+	kindUsers = "users"
+	kindMeta  = "meta"
+)
+
+// field selects the hash a setField call writes to.
+func (k sessionKeys) field(kind string) string {
+	if kind == kindMeta {
+		return k.meta
+	}
+	return k.users
+}
+
+// createScript creates the session unless it exists. KEYS are
+// sessionKeys.all(); ARGV is the TTL in seconds followed by the info hash
+// fields. Returns 1 when the session was created.
+var createScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'version', 1, unpack(ARGV, 2))
+redis.call('EXPIRE', KEYS[1], ARGV[1])
+return 1
+`)
+
+// setFieldScript sets or, with an empty value, deletes a field of the users
+// or meta hash and bumps the session version. KEYS are sessionKeys.all()
+// with the target hash last; ARGV is field, value, TTL and optionally the
+// value the field must still hold ("" for unset). Returns the new version,
+// or -1 when the field changed.
+var setFieldScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return redis.error_reply('session not found')
+end
+if ARGV[4] ~= nil and (redis.call('HGET', KEYS[5], ARGV[1]) or '') ~= ARGV[4] then
+	return -1
+end
+if ARGV[2] == '' then
+	redis.call('HDEL', KEYS[5], ARGV[1])
+else
+	redis.call('HSET', KEYS[5], ARGV[1], ARGV[2])
+end
+for i = 1, 4 do
+	redis.call('EXPIRE', KEYS[i], ARGV[3])
+end
+return redis.call('HINCRBY', KEYS[1], 'version', 1)
+`)
+
+// pushHistoryScript prepends an event to the history list, trims it to the
+// session's maxHistory and bumps the session version. KEYS are
+// sessionKeys.all(); ARGV is the JSON event and TTL.
+var pushHistoryScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return redis.error_reply('session not found')
+end
+redis.call('LPUSH', KEYS[4], ARGV[1])
+local max = tonumber(redis.call('HGET', KEYS[1], 'maxHistory'))
+if max and max > 0 then
+	redis.call('LTRIM', KEYS[4], 0, max - 1)
+end
+for i = 1, 4 do
+	redis.call('EXPIRE', KEYS[i], ARGV[2])
+end
+return redis.call('HINCRBY', KEYS[1], 'version', 1)
+`)
+
+// sessionStore keeps session state in Redis. Every mutation is a single
+// script that also bumps the session version, so any pod may change a
+// session at any time; read-modify-write updates go through updateField,
+// which retries when the field changed underneath it.
+type sessionStore struct {
+	c redis.Cmdable
+}
+
+// create stores session unless it already exists and reports whether it
+// did.
+func (st sessionStore) create(ctx context.Context, session *wsmodels.Session) (bool, error) {
+	k := keysFor(session.ID)
+	created, err := createScript.Run(ctx, st.c, k.all(), int(sessionTTL.Seconds()),
+		"id", session.ID,
+		"maxHistory", session.MaxHistory,
+		"idleDuration", int64(session.IdleDuration),
+	).Int()
+	if err != nil {
+		return false, fmt.Errorf("create: %w", err)
+	}
+	if created == 1 {
+		session.Version = 1
+	}
+	return created == 1, nil
+}
+
+// exists reports whether the session is still stored, i.e. was neither
+// ended nor expired.
+func (st sessionStore) exists(ctx context.Context, id string) (bool, error) {
+	n, err := st.c.Exists(ctx, keysFor(id).info).Result()
+	if err != nil {
+		return false, fmt.Errorf("exists: %w", err)
+	}
+	return n == 1, nil
+}
+
+// load reads a consistent snapshot of the session with its most recent
+// history, see maxRecentHistory.
+func (st sessionStore) load(ctx context.Context, id string) (*wsmodels.Session, error) {
+	k := keysFor(id)
+	var info, users, meta *redis.MapStringStringCmd
+	var history *redis.StringSliceCmd
+	_, err := st.c.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		info = p.HGetAll(ctx, k.info)
+		users = p.HGetAll(ctx, k.users)
+		meta = p.HGetAll(ctx, k.meta)
+		history = p.LRange(ctx, k.history, 0, maxRecentHistory-1)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load: %w", err)
+	}
+	if len(info.Val()) == 0 {
+		return nil, errSessionNotFound
+	}
+
+	session := &wsmodels.Session{
+		ID:      id,
+		Users:   make([]*wsmodels.User, 0, len(users.Val())),
+		History: make([]*wsmodels.Event, 0, len(history.Val())),
+		Meta:    make(map[string]interface{}, len(meta.Val())),
+	}
+	session.MaxHistory, _ = strconv.Atoi(info.Val()["maxHistory"])
+	idle, _ := strconv.ParseInt(info.Val()["idleDuration"], 10, 64)
+	session.IdleDuration = time.Duration(idle)
+	session.Version, _ = strconv.ParseInt(info.Val()["version"], 10, 64)
+
+	for _, raw := range users.Val() {
+		var u wsmodels.User
+		if err := json.Unmarshal([]byte(raw), &u); err != nil {
+			slog.Error("dropping unreadable session user", "err", err, "session", id)
+			continue
+		}
+		session.Users = append(session.Users, &u)
+	}
+	sort.Slice(session.Users, func(i, j int) bool {
+		return session.Users[i].Joined < session.Users[j].Joined
+	})
+	for key, raw := range meta.Val() {
+		var v interface{}
+		if err := json.Unmarshal([]byte(raw), &v); err != nil {
+			slog.Error("dropping unreadable session meta", "err", err, "session", id, "key", key)
+			continue
+		}
+		session.Meta[key] = v
+	}
+	// stored newest first, kept oldest first in memory
+	for i := len(history.Val()) - 1; i >= 0; i-- {
+		var e wsmodels.Event
+		if err := json.Unmarshal([]byte(history.Val()[i]), &e); err != nil {
+			slog.Error("dropping unreadable history event", "err", err, "session", id)
+			continue
+		}
+		session.History = append(session.History, &e)
+	}
+	return session, nil
+}
+
+// setField writes value (JSON encoded) to field of the users or meta hash
+// and returns the new session version. A nil value deletes the field.
+func (st sessionStore) setField(ctx context.Context, id, kind, field string, value interface{}) (int64, error) {
+	return st.casField(ctx, id, kind, field, value, nil)
+}
+
+// casField is setField that only writes while field still holds expected
+// (nil for unset), returning errVersionConflict otherwise.
+func (st sessionStore) casField(ctx context.Context, id, kind, field string, value interface{}, expected *string) (int64, error) {
+	var data []byte
+	if value != nil {
+		var err error
+		if data, err = json.Marshal(value); err != nil {
+			return 0, fmt.Errorf("setField: %w", err)
+		}
+	}
+	k := keysFor(id)
+	args := []interface{}{field, string(data), int(sessionTTL.Seconds())}
+	if expected != nil {
+		args = append(args, *expected)
+	}
+	version, err := setFieldScript.Run(ctx, st.c, append(k.all(), k.field(kind)), args...).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("setField: %w", scriptErr(err))
+	}
+	if version < 0 {
+		return 0, errVersionConflict
+	}
+	return version, nil
+}
+
+// updateField applies fn to the current JSON value of field (nil when
+// unset) and writes the result back, retrying with the new value if
+// another writer changed the field in between. fn returning nil deletes
+// the field. Returns the new session version.
+func (st sessionStore) updateField(ctx context.Context, id, kind, field string, fn func(current []byte) (interface{}, error)) (int64, error) {
+	k := keysFor(id)
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		if attempt > 0 {
+			// spread out writers that keep colliding
+			select {
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			case <-time.After(time.Duration(rand.Int64N(int64(attempt) * int64(time.Millisecond)))):
+			}
+		}
+		current, err := st.c.HGet(ctx, k.field(kind), field).Result()
+		var raw []byte
+		switch {
+		case errors.Is(err, redis.Nil):
+		case err != nil:
+			return 0, fmt.Errorf("updateField: %w", err)
+		default:
+			raw = []byte(current)
+		}
+		value, err := fn(raw)
+		if err != nil {
+			return 0, err
+		}
+		v, err := st.casField(ctx, id, kind, field, value, &current)
+		if errors.Is(err, errVersionConflict) {
+			continue
+		}
+		return v, err
+	}
+	return 0, fmt.Errorf("updateField: %w", errVersionConflict)
+}
+
+// pushHistory records e in the session history.
+func (st sessionStore) pushHistory(ctx context.Context, id string, e *wsmodels.Event) (int64, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return 0, fmt.Errorf("pushHistory: %w", err)
+	}
+	version, err := pushHistoryScript.Run(ctx, st.c, keysFor(id).all(), string(data), int(sessionTTL.Seconds())).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("pushHistory: %w", scriptErr(err))
+	}
+	return version, nil
+}
+
+// history returns up to limit events older than the event with ID before,
+// oldest first. An empty before starts from the newest event; an unknown
+// one returns nothing. The list is read historyPage events at a time until
+// before is found, then only as far as limit requires.
+func (st sessionStore) history(ctx context.Context, id, before string, limit int) ([]*wsmodels.Event, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	key := keysFor(id).history
+	events := make([]*wsmodels.Event, 0, limit)
+	// events pushed while paging shift the list, so a page may repeat the
+	// end of the previous one
+	seen := map[string]bool{}
+	found := before == ""
+	for start := int64(0); len(events) < limit; {
+		size := int64(historyPage)
+		if found {
+			size = int64(limit - len(events))
+		}
+		// newest first
+		raw, err := st.c.LRange(ctx, key, start, start+size-1).Result()
+		if err != nil {
+			return nil, fmt.Errorf("history: %w", err)
+		}
+		for _, r := range raw {
+			if len(events) == limit {
+				break
+			}
+			var e wsmodels.Event
+			if err := json.Unmarshal([]byte(r), &e); err != nil {
+				slog.Error("dropping unreadable history event", "err", err, "session", id)
+				continue
+			}
+			if !found {
+				found = e.ID == before
+				continue
+			}
+			if seen[e.ID] || e.ID == before {
+				continue
+			}
+			seen[e.ID] = true
+			events = append(events, &e)
+		}
+		if int64(len(raw)) < size {
+			break
+		}
+		start += size
+	}
+	slices.Reverse(events)
+	return events, nil
+}
+
+// delete removes all state of the session.
+func (st sessionStore) delete(ctx context.Context, id string) error {
+	k := keysFor(id)
+	if err := st.c.Del(ctx, append(k.all(), k.host)...).Err(); err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package wssession
+
+import (
+	"context"
+	"github.com/Seann-Moser/multiws/wsmodels"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"testing"
+)
+
+func TestStoreHistoryPages(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	defer client.Close()
+	st := sessionStore{c: client}
+	if _, err := st.create(ctx, &wsmodels.Session{ID: "s1"}); err != nil {
+		t.Fatal(err)
+	}
+	// more than a page, without a MaxHistory to trim it
+	const total = 2*historyPage + 50
+	for i := 0; i < total; i++ {
+		if _, err := st.pushHistory(ctx, "s1", &wsmodels.Event{ID: strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	next := total - 1
+	before := ""
+	for {
+		events, err := st.history(ctx, "s1", before, 30)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) == 0 {
+			break
+		}
+		// oldest first, continuing right before the previous page
+		for i := len(events) - 1; i >= 0; i-- {
+			if events[i].ID != strconv.Itoa(next) {
+				t.Fatalf("got event %s, want %d", events[i].ID, next)
+			}
+			next--
+		}
+		before = events[0].ID
+	}
+	if next != -1 {
+		t.Fatalf("paging stopped before event %d", next)
+	}
+
+	if events, err := st.history(ctx, "s1", "unknown", 10); err != nil || len(events) != 0 {
+		t.Fatalf("got %d events, %v for an unknown event", len(events), err)
+	}
+	session, err := st.load(ctx, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(session.History); n != maxRecentHistory || session.History[n-1].ID != strconv.Itoa(total-1) {
+		t.Fatalf("loaded %d events, want the latest %d", n, maxRecentHistory)
+	}
+}
+
+func TestRecentHistory(t *testing.T) {
+	for maxHistory, want := range map[int]int{
+		0:                    maxRecentHistory,
+		-1:                   maxRecentHistory,
+		20:                   20,
+		maxRecentHistory + 1: maxRecentHistory,
+	} {
+		if got := recentHistory(maxHistory); got != want {
+			t.Errorf("recentHistory(%d) = %d, want %d", maxHistory, got, want)
+		}
+	}
+}
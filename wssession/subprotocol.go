@@ -0,0 +1,58 @@
+package wssession
+
+import (
+	"github.com/Seann-Moser/multiws/wsmodels"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	subprotocolPrefix  = "multiws."
+	subprotocolVersion = ".v1"
+)
+
+// Subprotocol returns the Sec-WebSocket-Protocol value clients send to
+// select codec as their wire format, e.g. "multiws.msgpack.v1".
+func Subprotocol(codec wsmodels.Codec) string {
+	return subprotocolPrefix + codec.Name() + subprotocolVersion
+}
+
+// subprotocols lists the protocols offered to clients, in preference order.
+func (s *BaseSession) subprotocols() []string {
+	out := make([]string, 0, len(s.codecs))
+	for _, c := range s.codecs {
+		out = append(out, Subprotocol(c))
+	}
+	return out
+}
+
+// negotiatedCodec returns the codec for the protocol selected during the
+// upgrade. Clients that did not ask for a protocol get the default wire
+// codec; ok is false if they asked only for protocols we do not speak.
+func (s *BaseSession) negotiatedCodec(r *http.Request, conn *websocket.Conn) (codec wsmodels.Codec, ok bool) {
+	selected := conn.Subprotocol()
+	if selected == "" {
+		return s.wireCodec, len(websocket.Subprotocols(r)) == 0
+	}
+	for _, c := range s.codecs {
+		if Subprotocol(c) == selected {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// rejectSubprotocol closes conn with a protocol error naming the protocols
+// the server accepts.
+func (s *BaseSession) rejectSubprotocol(conn *websocket.Conn) {
+	reason := "unsupported subprotocol, expected one of: " + strings.Join(s.subprotocols(), ", ")
+	// close reasons are capped at 123 bytes
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	_ = conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseProtocolError, reason),
+		time.Now().Add(time.Second))
+}